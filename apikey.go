@@ -1,11 +1,13 @@
 package streamcommons
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 )
@@ -14,10 +16,17 @@ import (
 type APIKey struct {
 	Key  []byte
 	Demo bool
+	// Policy restricts data which can be fetched, loaded by LoadPolicy
+	Policy *KeyPolicy
 }
 
 // CheckAvalability returns error with detailed message
 func (a *APIKey) CheckAvalability(db *sql.DB) error {
+	return a.CheckAvalabilityIn(context.Background(), NewMySQLKeyStore(db))
+}
+
+// CheckAvalabilityIn returns error with detailed message using the key store
+func (a *APIKey) CheckAvalabilityIn(ctx context.Context, store KeyStore) error {
 	if a.Demo {
 		// availability of test apikey can not be checked
 		// this is a fail safe machanism so as to prevent bugs that allow
@@ -26,13 +35,11 @@ func (a *APIKey) CheckAvalability(db *sql.DB) error {
 	}
 
 	// check if API key is valid
-	var apikeyAvailable int
-	row := db.QueryRow("SELECT exchangedataset.apikey_available(?)", a.Key)
-	err := row.Scan(&apikeyAvailable)
+	available, err := store.Available(ctx, a.Key)
 	if err != nil {
-		return fmt.Errorf("apikey_available procedure call failed: %v", err)
+		return err
 	}
-	if apikeyAvailable != 1 {
+	if !available {
 		return errors.New("API key does not exist or reached the quota or is not enabled")
 	}
 	return nil
@@ -40,35 +47,98 @@ func (a *APIKey) CheckAvalability(db *sql.DB) error {
 
 // IncrementUsed tries to increment used count tied to API key
 func (a *APIKey) IncrementUsed(db *sql.DB, cost int) (err error) {
+	return a.IncrementUsedIn(context.Background(), NewMySQLKeyStore(db), cost)
+}
+
+// IncrementUsedIn tries to increment used count tied to API key in the key store
+func (a *APIKey) IncrementUsedIn(ctx context.Context, store KeyStore, cost int) (err error) {
 	if a.Demo {
 		return errors.New("IncrementUsed: this is demo test apikey, can not perform quota increment")
 	}
 	// increase api-key's quota used bytes
-	res, serr := db.Exec("CALL exchangedataset.increment_apikey_used_now(?, ?)", a.Key, cost)
+	serr := store.IncrementUsed(ctx, a.Key, cost)
 	if serr != nil {
-		return fmt.Errorf("IncrementUsed: Call failed: %v", serr)
+		return fmt.Errorf("IncrementUsed: %v", serr)
 	}
-	rows, serr := res.RowsAffected()
+	return
+}
+
+// Reservation is quota reserved for a request by APIKey.Reserve.
+// Either Commit or Release must be called when the request is done.
+type Reservation struct {
+	apikey   *APIKey
+	store    KeyStore
+	reserved int
+	done     bool
+	lock     sync.Mutex
+}
+
+// Reserve atomically checks availability of the API key and reserves the estimated cost of a request,
+// concurrent requests see quota reserved by others.
+func (a *APIKey) Reserve(ctx context.Context, store KeyStore, estimate int) (*Reservation, error) {
+	if a.Demo {
+		return nil, errors.New("Reserve: this is demo test apikey, can not reserve quota")
+	}
+	available, serr := store.Reserve(ctx, a.Key, estimate)
 	if serr != nil {
-		return fmt.Errorf("IncrementUsed: RowsAffected returned error: %v", serr)
+		return nil, fmt.Errorf("Reserve: %v", serr)
 	}
-	// This depends on the procedure in mysql
-	// SET counts as rowsAffected + actual update
-	if rows != 2 {
-		return errors.New("IncrementUsed: Too many or less rows affected: API key might not exist")
+	if !available {
+		return nil, errors.New("API key does not exist or does not have enough quota for the request or is not enabled")
 	}
-	return
+	return &Reservation{apikey: a, store: store, reserved: estimate}, nil
+}
+
+// Reserved returns the reserved cost.
+func (r *Reservation) Reserved() int {
+	return r.reserved
+}
+
+// Commit charges the actual cost instead of the reserved cost.
+// ctx should outlive the request, the reservation is left as it is if ctx is canceled.
+func (r *Reservation) Commit(ctx context.Context, cost int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done {
+		return errors.New("Commit: reservation is already committed or released")
+	}
+	var serr error
+	if cost > r.reserved {
+		serr = r.store.IncrementUsed(ctx, r.apikey.Key, cost-r.reserved)
+	} else if cost < r.reserved {
+		serr = r.store.Refund(ctx, r.apikey.Key, r.reserved-cost)
+	}
+	if serr != nil {
+		return fmt.Errorf("Commit: %v", serr)
+	}
+	r.done = true
+	return nil
+}
+
+// Release refunds the reserved cost for a failed request.
+// It does nothing if the reservation is already committed or released, so it can be deferred.
+// ctx should outlive the request so that the refund is not skipped when the request is canceled.
+func (r *Reservation) Release(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done {
+		return nil
+	}
+	serr := r.store.Refund(ctx, r.apikey.Key, r.reserved)
+	if serr != nil {
+		return fmt.Errorf("Release: %v", serr)
+	}
+	r.done = true
+	return nil
 }
 
 // CalcCost returns how much quota should be decremented if a request of specified amount were processed
+// using the default cost model
 func CalcCost(others int, orderbook int) (cost int) {
-	// Orderbook costs 1/5
-	cost = others + orderbook/5
-	// Request always consume 1kb of quota at minimun for spamming counter method
-	if cost < 1024 {
-		cost = 1024
-	}
-	return
+	return NewDefaultCostModel().Calc("", CostModeStream, map[ChannelGroup]int{
+		ChannelGroupOthers:    others,
+		ChannelGroupOrderbook: orderbook,
+	}).Total
 }
 
 // NewAPIKey creates new instance of APIKey from headers
@@ -86,7 +156,8 @@ func NewAPIKey(event events.APIGatewayProxyRequest) (apikey *APIKey, err error)
 	apikeyString := strings.TrimSpace(strings.TrimPrefix(headerAuthorization, "Bearer"))
 	if apikeyString == APIKeyDemo {
 		// demo apikey
-		return &APIKey{[]byte(APIKeyDemo), true}, nil
+		policy := DemoKeyPolicy()
+		return &APIKey{Key: []byte(APIKeyDemo), Demo: true, Policy: &policy}, nil
 	}
 	// normal apikey
 	var key []byte
@@ -95,5 +166,5 @@ func NewAPIKey(event events.APIGatewayProxyRequest) (apikey *APIKey, err error)
 		err = fmt.Errorf("base64 decoding failed: %v", err)
 		return
 	}
-	return &APIKey{key, false}, nil
+	return &APIKey{Key: key, Demo: false}, nil
 }
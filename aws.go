@@ -1,9 +1,17 @@
 package streamcommons
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/awserr"
@@ -17,147 +25,538 @@ const awsS3TestBucket = "exchangedataset-test-data"
 const awsS3ProductionRegion = "us-east-2"
 const awsS3ProductionBucket = "exchangedataset-data"
 
-var awsTest = true
-var awsDefaultConfig aws.Config
+// Profiles of S3Config
+const (
+	S3ProfileTest       = "test"
+	S3ProfileProduction = "production"
+)
 
-// AWSEnableProduction enables production mode.
-func AWSEnableProduction() {
-	awsTest = false
+// S3Config is the configuration of S3Storage.
+type S3Config struct {
+	Region string
+	Bucket string
+	// Endpoint is the URL of a S3 compatible server, the AWS endpoint is used if empty
+	Endpoint string
+	// ForcePathStyle makes requests with the bucket in path instead of host, needed by most S3 compatible servers
+	ForcePathStyle bool
 }
 
-func awsRegion() string {
-	if awsTest {
-		return awsS3TestRegion
+// S3ConfigForProfile returns the configuration of the bucket of the profile.
+func S3ConfigForProfile(profile string) (S3Config, error) {
+	switch profile {
+	case S3ProfileTest:
+		return S3Config{Region: awsS3TestRegion, Bucket: awsS3TestBucket}, nil
+	case S3ProfileProduction:
+		return S3Config{Region: awsS3ProductionRegion, Bucket: awsS3ProductionBucket}, nil
+	default:
+		return S3Config{}, fmt.Errorf("S3ConfigForProfile: unknown profile '%s'", profile)
 	}
-	return awsS3ProductionRegion
 }
 
-func awsBucket() string {
-	if awsTest {
-		return awsS3TestBucket
+// S3Storage is Storage on a S3 bucket.
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Storage creates new S3Storage from the configuration using the default AWS credentials.
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("NewS3Storage: bucket is not specified")
+	}
+	cfg, serr := external.LoadDefaultAWSConfig()
+	if serr != nil {
+		return nil, fmt.Errorf("NewS3Storage: %v", serr)
+	}
+	if config.Region != "" {
+		cfg.Region = config.Region
 	}
-	return awsS3ProductionBucket
+	if config.Endpoint != "" {
+		cfg.EndpointResolver = aws.ResolveWithEndpointURL(config.Endpoint)
+	}
+	s := new(S3Storage)
+	s.client = s3.New(cfg)
+	s.client.ForcePathStyle = config.ForcePathStyle
+	s.bucket = config.Bucket
+	return s, nil
 }
 
-func awsClient() *s3.Client {
-	cfg := awsDefaultConfig.Copy()
-	cfg.Region = awsRegion()
-	return s3.New(cfg)
+// Get gets object from the bucket, returns nil if the key does not exist.
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.getObject(ctx, key, nil)
 }
 
-// GetS3Object gets object from s3 bucket.
-func GetS3Object(ctx context.Context, key string) (io.ReadCloser, error) {
-	c := awsClient()
-	req := c.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(awsBucket()),
+// GetRange gets the range of object using the range request, returns nil if the key does not exist.
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("GetRange: negative offset")
+	}
+	if length == 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	var rangeHeader string
+	if length < 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-", offset)
+	} else {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	return s.getObject(ctx, key, &rangeHeader)
+}
+
+func (s *S3Storage) getObject(ctx context.Context, key string, rangeHeader *string) (io.ReadCloser, error) {
+	req := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
 		Key:    &key,
+		Range:  rangeHeader,
 	})
 	resp, serr := req.Send(ctx)
 	if serr != nil {
-		if aerr, ok := serr.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
-			// Key did not found
-			return nil, nil
+		if aerr, ok := serr.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				// Key did not found
+				return nil, nil
+			case "InvalidRange":
+				// Range starts at or after the end of the object
+				return ioutil.NopCloser(bytes.NewReader(nil)), nil
+			}
 		}
-		return nil, fmt.Errorf("GetS3Object: %v", serr)
+		return nil, &GetError{Key: key, Retryable: isS3Retryable(serr), Err: serr}
 	}
 	return resp.Body, nil
 }
 
-// PutS3Object puts object
-func PutS3Object(ctx context.Context, key string, body io.Reader) (err error) {
-	cfg := awsDefaultConfig.Copy()
-	cfg.Region = awsRegion()
-	uploader := s3manager.NewUploader(cfg)
-	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-		Bucket: aws.String(awsBucket()),
+// Stat gets the information of the object using the head request, returns nil if the key does not exist.
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	req := s.client.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
 		Key:    &key,
-		Body:   body,
 	})
-	return
-}
-
-// S3ListV2 downloads object metas which key starts from the given string.
-// func S3ListV2(ctx context.Context, prefix string) ([]string, error) {
-// 	cfg := awsDefaultConfig.Copy()
-// 	cfg.Region = awsRegion()
-// 	c := s3.New(cfg)
-// 	req := c.ListObjectsV2Request(&s3.ListObjectsV2Input{
-// 		Bucket: aws.String(awsBucket()),
-// 		Prefix: &prefix,
-// 	})
-// 	res, serr := req.Send(ctx)
-// 	if serr != nil {
-// 		return nil, serr
-// 	}
-// 	contents := res.Contents
-// 	keys := make([]string, len(contents))
-// 	for i, obj := range contents {
-// 		keys[i] = *obj.Key
-// 	}
-// 	return keys, nil
-// }
-
-// S3GetConcurrent is gets multiple objects from S3 in concurrent way.
-type S3GetConcurrent struct {
-	s3        *s3.Client
-	bucketKey string
-	keys      []string
-	bodies    chan io.ReadCloser
-	ctx       context.Context
-	cancel    context.CancelFunc
-	errc      chan error
-	lastErr   error
-	closed    bool
+	resp, serr := req.Send(ctx)
+	if serr != nil {
+		if rerr, ok := serr.(awserr.RequestFailure); ok && rerr.StatusCode() == 404 {
+			return nil, nil
+		}
+		return nil, &GetError{Key: key, Retryable: isS3Retryable(serr), Err: serr}
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(resp.ContentLength),
+		LastModified: aws.TimeValue(resp.LastModified),
+		ETag:         aws.StringValue(resp.ETag),
+		Metadata:     lowerKeys(resp.Metadata),
+	}, nil
 }
 
-type s3GetConcurrentResult struct {
-	key  string
-	body io.ReadCloser
-	err  error
+// lowerKeys converts keys of metadata to lower case as they are returned in the canonical header format.
+func lowerKeys(metadata map[string]string) map[string]string {
+	ret := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		ret[strings.ToLower(k)] = v
+	}
+	return ret
 }
 
-func (c *S3GetConcurrent) downloadRoutine(key string, result chan s3GetConcurrentResult) {
-	req := c.s3.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: &c.bucketKey,
+// Tags returns the tags of the object, returns *GetError with ErrObjectNotFound if the key does not exist.
+func (s *S3Storage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	req := s.client.GetObjectTaggingRequest(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.bucket),
 		Key:    &key,
 	})
-	resp, serr := req.Send(c.ctx)
+	resp, serr := req.Send(ctx)
 	if serr != nil {
 		if aerr, ok := serr.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
-			// Object did not found
-			result <- s3GetConcurrentResult{
-				key: key,
-			}
+			return nil, &GetError{Key: key, Err: ErrObjectNotFound}
+		}
+		return nil, &GetError{Key: key, Retryable: isS3Retryable(serr), Err: serr}
+	}
+	tags := make(map[string]string, len(resp.TagSet))
+	for _, t := range resp.TagSet {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return tags, nil
+}
+
+// isS3Retryable returns true if the error is throttling or server error.
+func isS3Retryable(err error) bool {
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		if rerr.StatusCode() >= 500 || rerr.StatusCode() == 429 {
+			return true
+		}
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "RequestTimeout":
+			return true
+		}
+	}
+	return false
+}
+
+// Put uploads object to the bucket with multipart upload tuned by the options.
+// If the checksum is requested for a body which is not seekable, the body is buffered into a temporary file
+// while computing the checksum, so that the object is uploaded once with the checksum in its metadata.
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, opts *PutOptions) error {
+	uploader := s3manager.NewUploaderWithClient(s.client, func(u *s3manager.Uploader) {
+		if opts == nil {
 			return
 		}
-		result <- s3GetConcurrentResult{
-			key: key,
-			err: serr,
+		if opts.PartSize > 0 {
+			u.PartSize = opts.PartSize
+		}
+		if opts.Concurrency > 0 {
+			u.Concurrency = opts.Concurrency
+		}
+	})
+	var cr *checksumReader
+	if opts != nil && opts.Checksum {
+		seeker, ok := body.(io.ReadSeeker)
+		if !ok {
+			tmp, serr := ioutil.TempFile("", "streamcommons-put-")
+			if serr != nil {
+				return fmt.Errorf("Put: %v", serr)
+			}
+			defer func() {
+				tmp.Close()
+				os.Remove(tmp.Name())
+			}()
+			_, serr = io.Copy(tmp, body)
+			if serr != nil {
+				return fmt.Errorf("Put: %v", serr)
+			}
+			_, serr = tmp.Seek(0, io.SeekStart)
+			if serr != nil {
+				return fmt.Errorf("Put: %v", serr)
+			}
+			seeker = tmp
+			body = tmp
+		}
+		// Body is uploaded from the current position
+		start, serr := seeker.Seek(0, io.SeekCurrent)
+		if serr != nil {
+			return fmt.Errorf("Put: %v", serr)
 		}
-		return
+		cr = newChecksumReader(seeker)
+		_, serr = io.Copy(ioutil.Discard, cr)
+		if serr != nil {
+			return fmt.Errorf("Put: %v", serr)
+		}
+		_, serr = seeker.Seek(start, io.SeekStart)
+		if serr != nil {
+			return fmt.Errorf("Put: %v", serr)
+		}
+	}
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      &key,
+		Body:     body,
+		Metadata: putMetadata(opts, cr),
+	}
+	if tags := putTags(opts); len(tags) > 0 {
+		input.Tagging = aws.String(encodeTags(tags))
+	}
+	_, serr := uploader.UploadWithContext(ctx, input)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	return nil
+}
+
+// encodeTags encodes tags in the url query format used by the tagging header.
+func encodeTags(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// s3ListMaxKeys is the maximum number of keys S3 returns in a page.
+const s3ListMaxKeys = 1000
+
+// List lists objects whose key starts with the prefix, following pages until MaxKeys objects are listed.
+func (s *S3Storage) List(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if opts.StartAfter != "" {
+		input.StartAfter = aws.String(opts.StartAfter)
+	}
+	if opts.MaxKeys > 0 && opts.MaxKeys < s3ListMaxKeys {
+		input.MaxKeys = aws.Int64(int64(opts.MaxKeys))
+	}
+	p := s3.NewListObjectsV2Paginator(s.client.ListObjectsV2Request(input))
+	objects := make([]ObjectInfo, 0)
+	for p.Next(ctx) {
+		for _, obj := range p.CurrentPage().Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+				ETag:         aws.StringValue(obj.ETag),
+			})
+			if opts.MaxKeys > 0 && len(objects) >= opts.MaxKeys {
+				return objects, nil
+			}
+		}
+	}
+	serr := p.Err()
+	if serr != nil {
+		return nil, fmt.Errorf("List: %v", serr)
 	}
+	return objects, nil
+}
+
+// GetS3Object gets object from the default storage.
+// Returns nil if the key does not exist.
+func GetS3Object(ctx context.Context, key string) (io.ReadCloser, error) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return nil, fmt.Errorf("GetS3Object: %v", serr)
+	}
+	body, serr := s.Get(ctx, key)
+	if serr != nil {
+		return nil, fmt.Errorf("GetS3Object: %v", serr)
+	}
+	return body, nil
+}
+
+// GetS3ObjectRange gets length bytes of the object from offset from the default storage,
+// or to the end if length is negative. Returns nil if the key does not exist.
+func GetS3ObjectRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return nil, fmt.Errorf("GetS3ObjectRange: %v", serr)
+	}
+	body, serr := s.GetRange(ctx, key, offset, length)
+	if serr != nil {
+		return nil, fmt.Errorf("GetS3ObjectRange: %v", serr)
+	}
+	return body, nil
+}
+
+// PutS3Object puts object to the default storage with the default options.
+func PutS3Object(ctx context.Context, key string, body io.Reader) error {
+	return PutS3ObjectWithOptions(ctx, key, body, nil)
+}
+
+// PutS3ObjectWithOptions puts object to the default storage with the options such as metadata and tags.
+func PutS3ObjectWithOptions(ctx context.Context, key string, body io.Reader, opts *PutOptions) error {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return fmt.Errorf("PutS3ObjectWithOptions: %v", serr)
+	}
+	serr = s.Put(ctx, key, body, opts)
+	if serr != nil {
+		return fmt.Errorf("PutS3ObjectWithOptions: %v", serr)
+	}
+	return nil
+}
+
+// GetS3ObjectVerified returns object from the default storage which verifies its checksum when read to the end.
+func GetS3ObjectVerified(ctx context.Context, key string) (io.ReadCloser, error) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return nil, fmt.Errorf("GetS3ObjectVerified: %v", serr)
+	}
+	return GetVerified(ctx, s, key)
+}
+
+// HeadObject returns the information of the object in the storage including its metadata and tags,
+// returns nil if the key does not exist.
+func HeadObject(ctx context.Context, s Storage, key string) (*ObjectInfo, error) {
+	info, serr := s.Stat(ctx, key)
+	if serr != nil {
+		return nil, fmt.Errorf("HeadObject: %v", serr)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	info.Tags, serr = s.Tags(ctx, key)
+	if serr != nil {
+		if errors.Is(serr, ErrObjectNotFound) {
+			// Deleted after stat
+			return nil, nil
+		}
+		return nil, fmt.Errorf("HeadObject: %v", serr)
+	}
+	if info.Tags == nil {
+		info.Tags = make(map[string]string)
+	}
+	return info, nil
+}
+
+// HeadS3Object returns the information of the object in the default storage including its metadata and tags,
+// returns nil if the key does not exist.
+func HeadS3Object(ctx context.Context, key string) (*ObjectInfo, error) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return nil, fmt.Errorf("HeadS3Object: %v", serr)
+	}
+	info, serr := HeadObject(ctx, s, key)
+	if serr != nil {
+		return nil, fmt.Errorf("HeadS3Object: %v", serr)
+	}
+	return info, nil
+}
+
+// S3ListV2 lists objects whose key starts with the prefix from the default storage.
+func S3ListV2(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return nil, fmt.Errorf("S3ListV2: %v", serr)
+	}
+	objects, serr := s.List(ctx, prefix, opts)
+	if serr != nil {
+		return nil, fmt.Errorf("S3ListV2: %v", serr)
+	}
+	return objects, nil
+}
+
+// S3GetConcurrent gets multiple objects from a storage in concurrent way.
+type S3GetConcurrent struct {
+	storage Storage
+	ranges  []ObjectRange
+	opts    GetAllOptions
+	results chan GetAllResult
+	ctx     context.Context
+	cancel  context.CancelFunc
+	errc    chan error
+	// nextErr is the failure Next stopped at
+	nextErr error
+	lastErr error
+	closed  bool
+}
+
+// GetAllOptions is the options to limit resources GetAll uses.
+type GetAllOptions struct {
+	// MaxInFlight is the maximum number of downloads running at once, 0 means no limit
+	MaxInFlight int
+	// PrefetchWindow is the number of objects which can be downloaded ahead of the consumer,
+	// including the one Next returns next, 0 means no limit
+	PrefetchWindow int
+	// MaxRetries is the number of retries for a retryable error of an object
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry, doubled on each retry with jitter
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay is the maximum delay between retries
+	RetryMaxDelay time.Duration
+	// ReportFailures makes missing and failed objects reported through NextResult with *GetError
+	// instead of aborting the whole download or returning nil body.
+	// Next still returns nil body for missing objects, but stops at a failed object and Close returns its error.
+	ReportFailures bool
+}
+
+// DefaultGetAllOptions is the options S3GetAll uses.
+var DefaultGetAllOptions = GetAllOptions{
+	MaxInFlight:    16,
+	PrefetchWindow: 32,
+	MaxRetries:     3,
+	RetryBaseDelay: 100 * time.Millisecond,
+	RetryMaxDelay:  5 * time.Second,
+}
+
+// GetAllResult is the result of an object downloaded by S3GetConcurrent.
+type GetAllResult struct {
+	Key string
+	// Body is nil if the object did not found or failed
+	Body io.ReadCloser
+	// Err is *GetError if the object is missing or failed in ReportFailures mode
+	Err error
+}
+
+type s3GetConcurrentResult struct {
+	index int
+	body  io.ReadCloser
+	err   *GetError
+}
+
+// retryDelay returns jittered delay before the retry of the given attempt starting from 0.
+func (c *S3GetConcurrent) retryDelay(attempt int) time.Duration {
+	delay := c.opts.RetryBaseDelay
+	for i := 0; i < attempt && (c.opts.RetryMaxDelay <= 0 || delay < c.opts.RetryMaxDelay); i++ {
+		delay *= 2
+	}
+	if c.opts.RetryMaxDelay > 0 && delay > c.opts.RetryMaxDelay {
+		delay = c.opts.RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// Full jitter
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func (c *S3GetConcurrent) download(r ObjectRange) (io.ReadCloser, *GetError) {
+	key := r.Key
+	for attempt := 0; ; attempt++ {
+		// Body is nil if the object did not found
+		var body io.ReadCloser
+		var serr error
+		if r.whole() {
+			body, serr = c.storage.Get(c.ctx, key)
+		} else {
+			body, serr = c.storage.GetRange(c.ctx, key, r.Offset, r.Length)
+		}
+		if serr == nil {
+			return body, nil
+		}
+		var gerr *GetError
+		if !errors.As(serr, &gerr) {
+			gerr = &GetError{Key: key, Err: serr}
+		}
+		if !gerr.Retryable || attempt >= c.opts.MaxRetries {
+			return nil, gerr
+		}
+		timer := time.NewTimer(c.retryDelay(attempt))
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, gerr
+		}
+	}
+}
+
+func (c *S3GetConcurrent) downloadRoutine(index int, result chan s3GetConcurrentResult) {
+	body, gerr := c.download(c.ranges[index])
 	result <- s3GetConcurrentResult{
-		key:  key,
-		body: resp.Body,
+		index: index,
+		body:  body,
+		err:   gerr,
 	}
 }
 
+// canStart returns true if the download of the object at index can be started.
+func (c *S3GetConcurrent) canStart(index int, running int, n int) bool {
+	if index >= len(c.ranges) {
+		return false
+	}
+	if c.opts.MaxInFlight > 0 && running >= c.opts.MaxInFlight {
+		return false
+	}
+	if c.opts.PrefetchWindow > 0 && index >= n+c.opts.PrefetchWindow {
+		return false
+	}
+	return true
+}
+
 func (c *S3GetConcurrent) managerRoutine() {
 	var err error
 	defer func() {
-		close(c.bodies)
-		if err != nil {
+		close(c.results)
+		var gerr *GetError
+		if errors.As(err, &gerr) {
+			// Let the caller know which key failed
+			c.errc <- gerr
+		} else if err != nil {
 			c.errc <- fmt.Errorf("managerRoutine: %v", err)
 		}
 		close(c.errc)
 	}()
-	// Make a map of index and its object key
-	keyMap := make(map[string]int)
-	for i, key := range c.keys {
-		keyMap[key] = i
-	}
 	results := make(chan s3GetConcurrentResult)
-	running := len(c.keys)
+	running := 0
 	defer func() {
 		// Wait for all child-routine to stop
 		for running > 0 {
@@ -176,15 +575,14 @@ func (c *S3GetConcurrent) managerRoutine() {
 			}
 		}
 	}()
-	for _, key := range c.keys {
-		go c.downloadRoutine(key, results)
-	}
-	buffer := make([]*s3GetConcurrentResult, len(c.keys))
+	buffer := make([]*s3GetConcurrentResult, len(c.ranges))
 	// Current position in the buffer
 	n := 0
+	// Index of the object to start downloading next
+	next := 0
 	defer func() {
 		// Close all buffered bodies
-		for ; n < len(c.keys); n++ {
+		for ; n < len(c.ranges); n++ {
 			if buffer[n] == nil || buffer[n].body == nil {
 				continue
 			}
@@ -199,19 +597,33 @@ func (c *S3GetConcurrent) managerRoutine() {
 			}
 		}
 	}()
-	for running > 0 {
+	for n < len(c.ranges) {
+		for ; c.canStart(next, running, n); next++ {
+			go c.downloadRoutine(next, results)
+			running++
+		}
 		select {
 		case r := <-results:
 			running--
-			if r.err != nil {
+			if r.err != nil && !c.opts.ReportFailures {
 				err = r.err
 				return
 			}
-			i := keyMap[r.key]
-			buffer[i] = &r
-			for ; n < len(c.keys) && buffer[n] != nil; n++ {
+			buffer[r.index] = &r
+			for ; n < len(c.ranges) && buffer[n] != nil; n++ {
+				result := GetAllResult{
+					Key:  c.ranges[n].Key,
+					Body: buffer[n].body,
+				}
+				if buffer[n].err != nil {
+					result.Err = buffer[n].err
+				} else if buffer[n].body == nil && c.opts.ReportFailures {
+					result.Err = &GetError{Key: c.ranges[n].Key, Err: ErrObjectNotFound}
+				}
 				select {
-				case c.bodies <- buffer[n].body:
+				case c.results <- result:
+					// Release the reference as it is no longer owned by this routine
+					buffer[n] = nil
 				case <-c.ctx.Done():
 					err = c.ctx.Err()
 					return
@@ -224,26 +636,82 @@ func (c *S3GetConcurrent) managerRoutine() {
 	}
 }
 
-// S3GetAll downloads all objects with the given keys concurrently.
+// S3GetAll downloads all objects with the given keys concurrently from the default storage
+// using DefaultGetAllOptions.
 func S3GetAll(ctx context.Context, keys []string) *S3GetConcurrent {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return newFailedGetConcurrent(fmt.Errorf("S3GetAll: %v", serr))
+	}
+	return GetAll(ctx, s, keys, DefaultGetAllOptions)
+}
+
+// S3GetAllRanges downloads the ranges of objects concurrently from the default storage
+// using DefaultGetAllOptions.
+func S3GetAllRanges(ctx context.Context, ranges []ObjectRange) *S3GetConcurrent {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return newFailedGetConcurrent(fmt.Errorf("S3GetAllRanges: %v", serr))
+	}
+	return GetAllRanges(ctx, s, ranges, DefaultGetAllOptions)
+}
+
+// GetAll downloads all objects with the given keys concurrently from the storage.
+// Bodies are returned by Next in the same order as keys, nil for keys which do not exist.
+func GetAll(ctx context.Context, s Storage, keys []string, opts GetAllOptions) *S3GetConcurrent {
+	ranges := make([]ObjectRange, len(keys))
+	for i, key := range keys {
+		ranges[i] = ObjectRange{Key: key, Length: -1}
+	}
+	return GetAllRanges(ctx, s, ranges, opts)
+}
+
+// GetAllRanges downloads the ranges of objects concurrently from the storage in the same way as GetAll.
+func GetAllRanges(ctx context.Context, s Storage, ranges []ObjectRange, opts GetAllOptions) *S3GetConcurrent {
 	c := new(S3GetConcurrent)
 	c.errc = make(chan error)
-	c.bodies = make(chan io.ReadCloser)
-	c.keys = keys
-	c.bucketKey = awsBucket()
+	c.results = make(chan GetAllResult)
+	c.ranges = ranges
+	c.opts = opts
+	c.storage = s
 	c.ctx, c.cancel = context.WithCancel(ctx)
-	// Create new S3 client
-	cfg := awsDefaultConfig.Copy()
-	cfg.Region = awsRegion()
-	c.s3 = s3.New(cfg)
 	go c.managerRoutine()
 	return c
 }
 
+// newFailedGetConcurrent returns S3GetConcurrent which has no bodies and returns the error on Close.
+func newFailedGetConcurrent(err error) *S3GetConcurrent {
+	c := new(S3GetConcurrent)
+	c.results = make(chan GetAllResult)
+	close(c.results)
+	c.cancel = func() {}
+	c.closed = true
+	c.lastErr = err
+	return c
+}
+
 // Next returns next reader for body.
-// Returns nil if object is exhausted.
+// Body is nil if the object did not found, ok is false if objects are exhausted.
+// In ReportFailures mode, ok is false on an object failed to download and Close returns its error,
+// use NextResult to continue after failures.
 func (c *S3GetConcurrent) Next() (body io.ReadCloser, ok bool) {
-	body, ok = <-c.bodies
+	if c.nextErr != nil {
+		return nil, false
+	}
+	result, ok := <-c.results
+	if ok && result.Err != nil && !errors.Is(result.Err, ErrObjectNotFound) {
+		// Next can not return the error, stop downloading and let Close return it
+		c.nextErr = result.Err
+		c.cancel()
+		return nil, false
+	}
+	return result.Body, ok
+}
+
+// NextResult returns the result of the next object with its key and error in ReportFailures mode.
+// ok is false if objects are exhausted.
+func (c *S3GetConcurrent) NextResult() (result GetAllResult, ok bool) {
+	result, ok = <-c.results
 	return
 }
 
@@ -253,18 +721,11 @@ func (c *S3GetConcurrent) Close() error {
 		return c.lastErr
 	}
 	c.cancel()
-	serr, ok := <-c.errc
-	if !ok {
-		c.lastErr = serr
-	}
-	return serr
-}
-
-func init() {
-	// Setup
-	var serr error
-	awsDefaultConfig, serr = external.LoadDefaultAWSConfig()
-	if serr != nil {
-		panic(serr)
+	c.lastErr = <-c.errc
+	if c.nextErr != nil {
+		// The error of the manager is just the cancellation by Next
+		c.lastErr = c.nextErr
 	}
+	c.closed = true
+	return c.lastErr
 }
@@ -17,6 +17,41 @@ const (
 	ChannelGroupOthers
 )
 
+// Names of channel groups in text
+var channelGroupNames = map[ChannelGroup]string{
+	ChannelGroupOrderbook: "orderbook",
+	ChannelGroupTrade:     "trade",
+	ChannelGroupOthers:    "others",
+}
+
+func (cg ChannelGroup) String() string {
+	name, ok := channelGroupNames[cg]
+	if !ok {
+		return fmt.Sprintf("ChannelGroup(%d)", int(cg))
+	}
+	return name
+}
+
+// MarshalText marshals the channel group into its name.
+func (cg ChannelGroup) MarshalText() ([]byte, error) {
+	name, ok := channelGroupNames[cg]
+	if !ok {
+		return nil, fmt.Errorf("MarshalText: unknown channel group %d", int(cg))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText unmarshals the name of a channel group.
+func (cg *ChannelGroup) UnmarshalText(text []byte) error {
+	for c, name := range channelGroupNames {
+		if name == string(text) {
+			*cg = c
+			return nil
+		}
+	}
+	return fmt.Errorf("UnmarshalText: unknown channel group '%s'", text)
+}
+
 // GetChannelGroup returns the channel group of the channel of the given exchange.
 func GetChannelGroup(exchange string, channel string) (cg ChannelGroup, err error) {
 	cg = ChannelGroupOthers
@@ -57,6 +92,12 @@ func GetChannelGroup(exchange string, channel string) (cg ChannelGroup, err erro
 		} else if strings.HasPrefix(channel, LiquidChannelPrefixExecutionsCash) {
 			cg = ChannelGroupTrade
 		}
+	case "bitbank":
+		if strings.HasPrefix(channel, BitbankChannelPrefixDepthWhole) || strings.HasPrefix(channel, BitbankChannelPrefixDepthDiff) {
+			cg = ChannelGroupOrderbook
+		} else if strings.HasPrefix(channel, BitbankChannelPrefixTransactions) {
+			cg = ChannelGroupTrade
+		}
 	default:
 		err = fmt.Errorf("getChannelType: exchange '%v' is not supported", exchange)
 		return
@@ -7,6 +7,7 @@ const (
 	ExchangeBitmex   = "bitmex"
 	ExchangeBinance  = "binance"
 	ExchangeLiquid   = "liquid"
+	ExchangeBitbank  = "bitbank"
 )
 
 // Bitfinex related
@@ -52,6 +53,16 @@ const (
 	LiquidChannelPrefixExecutionsCash  = "executions_cash_"
 )
 
+// Bitbank related
+const (
+	BitbankChannelPrefixTicker       = "ticker_"
+	BitbankChannelPrefixTransactions = "transactions_"
+	BitbankChannelPrefixDepthWhole   = "depth_whole_"
+	BitbankChannelPrefixDepthDiff    = "depth_diff_"
+	BitbankChannelWelcome            = "welcome"
+	BitbankChannelConnected          = "connected"
+)
+
 // Common format
 const (
 	CommonFormatSell    = "Sell"
@@ -59,8 +70,21 @@ const (
 	CommonFormatUnknown = "Unknown"
 )
 
+// Type of lines in dump files
+const (
+	LineTypeStart   = "start"
+	LineTypeEnd     = "end"
+	LineTypeMessage = "msg"
+	LineTypeSend    = "send"
+	LineTypeState   = "state"
+	LineTypeError   = "err"
+)
+
 // StateChannelSubscribed is the channel name for subscribed channel message in status line
 const StateChannelSubscribed = "!subscribed"
 
+// StateChannelSubscribing is the channel name for subscribe requests that have not received responses yet in status line
+const StateChannelSubscribing = "!subscribing"
+
 // ChannelUnknown is the placeholder for message whose channel could not be specified
 const ChannelUnknown = "!unknown"
@@ -0,0 +1,148 @@
+package streamcommons
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Modes of requests for CostModel
+const (
+	// CostModeStream is for requests which stream lines in a range of time.
+	CostModeStream = "stream"
+	// CostModeSnapshot is for requests which return states at a time.
+	CostModeSnapshot = "snapshot"
+)
+
+// CostModel calculates how much quota a request consumes from bytes transferred for each channel group.
+// Cost of a channel group is bytes multiplied by weights of the channel group, the exchange and the mode.
+// Weights which are not specified are 1.
+type CostModel struct {
+	ChannelGroupWeights map[ChannelGroup]float64 `json:"channelGroupWeights,omitempty"`
+	ExchangeWeights     map[string]float64       `json:"exchangeWeights,omitempty"`
+	ModeWeights         map[string]float64       `json:"modeWeights,omitempty"`
+	// Minimum is the minimum cost of a request
+	Minimum int `json:"minimum"`
+}
+
+// NewDefaultCostModel returns the cost model which orderbook costs 1/5 and a request costs 1KB at minimum.
+func NewDefaultCostModel() *CostModel {
+	return &CostModel{
+		ChannelGroupWeights: map[ChannelGroup]float64{
+			ChannelGroupOrderbook: 0.2,
+		},
+		// Request always consume 1kb of quota at minimun for spamming counter method
+		Minimum: 1024,
+	}
+}
+
+// CostModelFromJSON parses the cost model in json on top of the default cost model,
+// fields not in json keep the default and weights are merged into the default ones.
+// Returns error if any weight or the minimum is negative.
+func CostModelFromJSON(data []byte) (*CostModel, error) {
+	m := NewDefaultCostModel()
+	serr := json.Unmarshal(data, m)
+	if serr != nil {
+		return nil, fmt.Errorf("CostModelFromJSON: %v", serr)
+	}
+	serr = m.validate()
+	if serr != nil {
+		return nil, fmt.Errorf("CostModelFromJSON: %v", serr)
+	}
+	return m, nil
+}
+
+// validate returns error if the model could make negative costs, which would refund quota.
+func (m *CostModel) validate() error {
+	if m.Minimum < 0 {
+		return fmt.Errorf("minimum is negative: %d", m.Minimum)
+	}
+	for cg, w := range m.ChannelGroupWeights {
+		if !(w >= 0) {
+			return fmt.Errorf("weight of channel group '%s' is negative: %v", cg, w)
+		}
+	}
+	for exchange, w := range m.ExchangeWeights {
+		if !(w >= 0) {
+			return fmt.Errorf("weight of exchange '%s' is negative: %v", exchange, w)
+		}
+	}
+	for mode, w := range m.ModeWeights {
+		if !(w >= 0) {
+			return fmt.Errorf("weight of mode '%s' is negative: %v", mode, w)
+		}
+	}
+	return nil
+}
+
+// CostModelFromEnv reads the cost model in json from COST_MODEL environment variable,
+// returns the default cost model if it is not set.
+func CostModelFromEnv() (*CostModel, error) {
+	data := os.Getenv("COST_MODEL")
+	if data == "" {
+		return NewDefaultCostModel(), nil
+	}
+	m, serr := CostModelFromJSON([]byte(data))
+	if serr != nil {
+		return nil, fmt.Errorf("CostModelFromEnv: %v", serr)
+	}
+	return m, nil
+}
+
+// weight returns the weight of the key, 1 if it is not specified.
+func weight(weights map[string]float64, key string) float64 {
+	w, ok := weights[key]
+	if !ok {
+		return 1
+	}
+	return w
+}
+
+// channelGroupWeight is weight for channel groups.
+func channelGroupWeight(weights map[ChannelGroup]float64, cg ChannelGroup) float64 {
+	w, ok := weights[cg]
+	if !ok {
+		return 1
+	}
+	return w
+}
+
+// CostBreakdown is the cost of a request for each channel group.
+type CostBreakdown struct {
+	Groups map[ChannelGroup]int
+	// MinimumApplied is true if Total is raised to the minimum cost
+	MinimumApplied bool
+	Total          int
+}
+
+// Calc calculates the cost of a request from bytes transferred for each channel group.
+func (m *CostModel) Calc(exchange string, mode string, bytes map[ChannelGroup]int) *CostBreakdown {
+	b := &CostBreakdown{Groups: make(map[ChannelGroup]int, len(bytes))}
+	common := weight(m.ExchangeWeights, exchange) * weight(m.ModeWeights, mode)
+	for cg, n := range bytes {
+		cost := int(math.Floor(float64(n) * channelGroupWeight(m.ChannelGroupWeights, cg) * common))
+		b.Groups[cg] = cost
+		b.Total += cost
+	}
+	if b.Total < m.Minimum {
+		b.Total = m.Minimum
+		b.MinimumApplied = true
+	}
+	return b
+}
+
+// SetHeaders sets headers of the cost of each channel group, Total should be set in ExcDataset-Quota-Used.
+// Unknown channel groups are skipped as they do not make a valid header name.
+func (b *CostBreakdown) SetHeaders(headers map[string]string) {
+	for cg, cost := range b.Groups {
+		name, serr := cg.MarshalText()
+		if serr != nil {
+			continue
+		}
+		headers["ExcDataset-Quota-Used-"+strings.Title(string(name))] = strconv.Itoa(cost)
+	}
+	headers["ExcDataset-Quota-Minimum-Applied"] = strconv.FormatBool(b.MinimumApplied)
+}
@@ -10,3 +10,12 @@ const DemoAPIKeyAllowedStart = 1577836800 * time.Second
 
 // DemoAPIKeyAllowedEnd is the end date of range of time that data is allowed to be fetched using demo apikey
 const DemoAPIKeyAllowedEnd = (1577836800 + 60*60) * time.Second
+
+// DemoKeyPolicy returns the policy of the demo API-key, which only allows the range of time above.
+// It is made every time so that modifying it does not affect others.
+func DemoKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		AllowedStart: int64(DemoAPIKeyAllowedStart),
+		AllowedEnd:   int64(DemoAPIKeyAllowedEnd),
+	}
+}
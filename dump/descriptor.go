@@ -0,0 +1,133 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons"
+)
+
+// Keys of the object metadata for Descriptor
+const (
+	descriptorKeyVersion  = "dump-version"
+	descriptorKeyExchange = "dump-exchange"
+	descriptorKeyStart    = "dump-start"
+	descriptorKeyEnd      = "dump-end"
+	descriptorKeyChannels = "dump-channels"
+	descriptorKeyLines    = "dump-lines"
+)
+
+// DescriptorVersion is the format version of dump files written by this package.
+const DescriptorVersion = 1
+
+// Descriptor describes the content of a dump object, it is stored in the object metadata
+// so that it can be known without reading the object.
+// S3 limits the total size of metadata to 2KB, which limits the number of channels.
+type Descriptor struct {
+	// Version is the format version of the dump file
+	Version  int
+	Exchange string
+	// Start is the timestamp of the first line in unix nanosecond
+	Start int64
+	// End is the timestamp of the last line in unix nanosecond
+	End int64
+	// Channels is the sorted list of channels which appeared in the dump file
+	Channels []string
+	// Lines is the number of lines in the dump file
+	Lines int64
+}
+
+// NewDescriptor creates new empty Descriptor for the dump file of the exchange.
+func NewDescriptor(exchange string) *Descriptor {
+	d := new(Descriptor)
+	d.Version = DescriptorVersion
+	d.Exchange = exchange
+	d.Channels = make([]string, 0)
+	return d
+}
+
+// Add updates the descriptor with a line written to the dump file.
+func (d *Descriptor) Add(l *Line) {
+	if d.Lines == 0 || l.Timestamp < d.Start {
+		d.Start = l.Timestamp
+	}
+	if d.Lines == 0 || l.Timestamp > d.End {
+		d.End = l.Timestamp
+	}
+	d.Lines++
+	if l.Channel == "" {
+		return
+	}
+	i := sort.SearchStrings(d.Channels, l.Channel)
+	if i < len(d.Channels) && d.Channels[i] == l.Channel {
+		return
+	}
+	d.Channels = append(d.Channels, "")
+	copy(d.Channels[i+1:], d.Channels[i:])
+	d.Channels[i] = l.Channel
+}
+
+// WriteDescriptor sets the descriptor to the metadata of the options for Storage.Put.
+func WriteDescriptor(opts *streamcommons.PutOptions, d *Descriptor) {
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+	opts.Metadata[descriptorKeyVersion] = strconv.Itoa(d.Version)
+	opts.Metadata[descriptorKeyExchange] = d.Exchange
+	opts.Metadata[descriptorKeyStart] = strconv.FormatInt(d.Start, 10)
+	opts.Metadata[descriptorKeyEnd] = strconv.FormatInt(d.End, 10)
+	opts.Metadata[descriptorKeyChannels] = strings.Join(d.Channels, ",")
+	opts.Metadata[descriptorKeyLines] = strconv.FormatInt(d.Lines, 10)
+}
+
+// ReadDescriptor reads the descriptor from the object metadata, returns nil if the object does not have it.
+func ReadDescriptor(metadata map[string]string) (*Descriptor, error) {
+	version, ok := metadata[descriptorKeyVersion]
+	if !ok {
+		return nil, nil
+	}
+	d := new(Descriptor)
+	var serr error
+	d.Version, serr = strconv.Atoi(version)
+	if serr != nil {
+		return nil, fmt.Errorf("ReadDescriptor: version: %v", serr)
+	}
+	d.Exchange = metadata[descriptorKeyExchange]
+	d.Start, serr = strconv.ParseInt(metadata[descriptorKeyStart], 10, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("ReadDescriptor: start: %v", serr)
+	}
+	d.End, serr = strconv.ParseInt(metadata[descriptorKeyEnd], 10, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("ReadDescriptor: end: %v", serr)
+	}
+	d.Channels = make([]string, 0)
+	if channels := metadata[descriptorKeyChannels]; channels != "" {
+		d.Channels = strings.Split(channels, ",")
+	}
+	d.Lines, serr = strconv.ParseInt(metadata[descriptorKeyLines], 10, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("ReadDescriptor: lines: %v", serr)
+	}
+	return d, nil
+}
+
+// StatDescriptor reads the descriptor of the dump object in the storage,
+// returns nil if the object does not exist or does not have a descriptor.
+func StatDescriptor(ctx context.Context, s streamcommons.Storage, key string) (*Descriptor, error) {
+	info, serr := s.Stat(ctx, key)
+	if serr != nil {
+		return nil, fmt.Errorf("StatDescriptor: %v", serr)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	d, serr := ReadDescriptor(info.Metadata)
+	if serr != nil {
+		return nil, fmt.Errorf("StatDescriptor: %v", serr)
+	}
+	return d, nil
+}
@@ -0,0 +1,101 @@
+package dump
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// Simulate feeds a line to the simulator.
+// Lines of end and err are ignored.
+func Simulate(sim simulator.Simulator, l *Line) error {
+	serr := simulator.ProcessLine(sim, l.Type, l.Channel, l.Message)
+	if serr != nil {
+		return fmt.Errorf("Simulate: %v", serr)
+	}
+	return nil
+}
+
+// SimulateAll feeds all lines from r to the simulator until EOF.
+func SimulateAll(r io.Reader, sim simulator.Simulator) (err error) {
+	d, serr := NewReader(r)
+	if serr != nil {
+		return fmt.Errorf("SimulateAll: %v", serr)
+	}
+	defer func() {
+		serr := d.Close()
+		if serr != nil && err == nil {
+			err = fmt.Errorf("SimulateAll: %v", serr)
+		}
+	}()
+	for {
+		l, serr := d.Read()
+		if serr == io.EOF {
+			return nil
+		}
+		if serr != nil {
+			return fmt.Errorf("SimulateAll: %v", serr)
+		}
+		serr = Simulate(sim, l)
+		if serr != nil {
+			return fmt.Errorf("SimulateAll: %v", serr)
+		}
+	}
+}
+
+// Format formats a line with the formatter.
+// Only lines of start and message of the supported channels are formatted, returns nil for others.
+// The timestamp of the line is given to the formatter if it is formatter.TimestampFormatter.
+func Format(f formatter.Formatter, l *Line) (results []formatter.Result, err error) {
+	switch l.Type {
+	case TypeStart:
+		results, err = f.FormatStart(string(l.Message))
+	case TypeMessage:
+		if !f.IsSupported(l.Channel) {
+			return nil, nil
+		}
+		if tf, ok := f.(formatter.TimestampFormatter); ok {
+			results, err = tf.FormatMessageAt(l.Channel, l.Timestamp, l.Message)
+		} else {
+			results, err = f.FormatMessage(l.Channel, l.Message)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Format: %v", err)
+	}
+	return
+}
+
+// FormatAll formats all lines from r with the formatter until EOF.
+// callback is called for every line with its formatted results, stops if it returns error.
+func FormatAll(r io.Reader, f formatter.Formatter, callback func(l *Line, results []formatter.Result) error) (err error) {
+	d, serr := NewReader(r)
+	if serr != nil {
+		return fmt.Errorf("FormatAll: %v", serr)
+	}
+	defer func() {
+		serr := d.Close()
+		if serr != nil && err == nil {
+			err = fmt.Errorf("FormatAll: %v", serr)
+		}
+	}()
+	for {
+		l, serr := d.Read()
+		if serr == io.EOF {
+			return nil
+		}
+		if serr != nil {
+			return fmt.Errorf("FormatAll: %v", serr)
+		}
+		results, serr := Format(f, l)
+		if serr != nil {
+			return fmt.Errorf("FormatAll: %v", serr)
+		}
+		serr = callback(l, results)
+		if serr != nil {
+			return serr
+		}
+	}
+}
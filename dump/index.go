@@ -0,0 +1,220 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/exchangedataset/streamcommons"
+	"github.com/exchangedataset/streamcommons/simulator"
+)
+
+// Type of lines in index files
+const (
+	indexTypeSeek  = "seek"
+	indexTypeBlock = "block"
+	indexTypeState = "state"
+)
+
+// IndexKeySuffix is appended to the key of a dump object to make the key of its index.
+const IndexKeySuffix = ".idx"
+
+// SeekPoint is a point in a dump file where reading can be started from.
+// A gzip stream starts at every seek point in gzip compressed dump files.
+type SeekPoint struct {
+	// Timestamp of the first line after this point
+	Timestamp int64
+	// Offset in the dump file
+	Offset int64
+}
+
+// StateLine is the position of a state line in a state block.
+type StateLine struct {
+	Channel string
+	// Offset from the start of the block in uncompressed bytes
+	Offset int64
+	// Length of the line without line feed
+	Length int64
+}
+
+// StateBlock is a block of contiguous state lines, it always starts at a seek point.
+type StateBlock struct {
+	SeekPoint
+	Lines []StateLine
+}
+
+// Index is the sidecar index of a dump file to start reading from the middle of it.
+// Seek points and state blocks are sorted by timestamp.
+type Index struct {
+	SeekPoints  []SeekPoint
+	StateBlocks []StateBlock
+}
+
+// IndexKey returns the key of the index for the dump object.
+func IndexKey(key string) string {
+	return key + IndexKeySuffix
+}
+
+// StateBlockBefore returns the last state block which starts at or before the timestamp, nil if there is none.
+func (idx *Index) StateBlockBefore(timestamp int64) *StateBlock {
+	i := sort.Search(len(idx.StateBlocks), func(i int) bool {
+		return idx.StateBlocks[i].Timestamp > timestamp
+	})
+	if i == 0 {
+		return nil
+	}
+	return &idx.StateBlocks[i-1]
+}
+
+// SeekPointAfter returns the first seek point whose timestamp is after the timestamp, nil if there is none.
+// All lines up to the timestamp are before the returned point.
+func (idx *Index) SeekPointAfter(timestamp int64) *SeekPoint {
+	i := sort.Search(len(idx.SeekPoints), func(i int) bool {
+		return idx.SeekPoints[i].Timestamp > timestamp
+	})
+	if i == len(idx.SeekPoints) {
+		return nil
+	}
+	return &idx.SeekPoints[i]
+}
+
+// WriteIndex writes the index in tab separated text.
+func WriteIndex(w io.Writer, idx *Index) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range idx.SeekPoints {
+		_, serr := fmt.Fprintf(bw, "%s\t%d\t%d\n", indexTypeSeek, p.Timestamp, p.Offset)
+		if serr != nil {
+			return fmt.Errorf("WriteIndex: %v", serr)
+		}
+	}
+	for _, b := range idx.StateBlocks {
+		_, serr := fmt.Fprintf(bw, "%s\t%d\t%d\n", indexTypeBlock, b.Timestamp, b.Offset)
+		if serr != nil {
+			return fmt.Errorf("WriteIndex: %v", serr)
+		}
+		for _, l := range b.Lines {
+			_, serr := fmt.Fprintf(bw, "%s\t%d\t%d\t%s\n", indexTypeState, l.Offset, l.Length, l.Channel)
+			if serr != nil {
+				return fmt.Errorf("WriteIndex: %v", serr)
+			}
+		}
+	}
+	serr := bw.Flush()
+	if serr != nil {
+		return fmt.Errorf("WriteIndex: %v", serr)
+	}
+	return nil
+}
+
+// ReadIndex reads the index written by WriteIndex.
+func ReadIndex(r io.Reader) (*Index, error) {
+	idx := new(Index)
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		fields := bytes.Split(s.Bytes(), []byte{'\t'})
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("ReadIndex: line %d: too few fields", n)
+		}
+		first, serr := strconv.ParseInt(string(fields[1]), 10, 64)
+		if serr != nil {
+			return nil, fmt.Errorf("ReadIndex: line %d: %v", n, serr)
+		}
+		second, serr := strconv.ParseInt(string(fields[2]), 10, 64)
+		if serr != nil {
+			return nil, fmt.Errorf("ReadIndex: line %d: %v", n, serr)
+		}
+		switch string(fields[0]) {
+		case indexTypeSeek:
+			idx.SeekPoints = append(idx.SeekPoints, SeekPoint{Timestamp: first, Offset: second})
+		case indexTypeBlock:
+			idx.StateBlocks = append(idx.StateBlocks, StateBlock{SeekPoint: SeekPoint{Timestamp: first, Offset: second}})
+		case indexTypeState:
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("ReadIndex: line %d: no channel field", n)
+			}
+			if len(idx.StateBlocks) == 0 {
+				return nil, fmt.Errorf("ReadIndex: line %d: state line without block", n)
+			}
+			b := &idx.StateBlocks[len(idx.StateBlocks)-1]
+			b.Lines = append(b.Lines, StateLine{Channel: string(fields[3]), Offset: first, Length: second})
+		default:
+			return nil, fmt.Errorf("ReadIndex: line %d: unknown type '%s'", n, fields[0])
+		}
+	}
+	serr := s.Err()
+	if serr != nil {
+		return nil, fmt.Errorf("ReadIndex: %v", serr)
+	}
+	return idx, nil
+}
+
+// LoadIndex reads the index of the dump object from the storage, returns nil if it does not exist.
+func LoadIndex(ctx context.Context, s streamcommons.Storage, key string) (*Index, error) {
+	body, serr := s.Get(ctx, IndexKey(key))
+	if serr != nil {
+		return nil, fmt.Errorf("LoadIndex: %v", serr)
+	}
+	if body == nil {
+		return nil, nil
+	}
+	defer body.Close()
+	idx, serr := ReadIndex(body)
+	if serr != nil {
+		return nil, fmt.Errorf("LoadIndex: %v", serr)
+	}
+	return idx, nil
+}
+
+// SimulateAt starts the simulator from the nearest state block at or before the timestamp in the dump object,
+// and processes all lines up to the timestamp using range reads.
+// The simulator should be newly created, it starts from the beginning of the dump if there is no state block before.
+func SimulateAt(ctx context.Context, s streamcommons.Storage, key string, idx *Index, sim simulator.Simulator, timestamp int64) (err error) {
+	var start int64
+	if b := idx.StateBlockBefore(timestamp); b != nil {
+		start = b.Offset
+	}
+	length := int64(-1)
+	if p := idx.SeekPointAfter(timestamp); p != nil {
+		length = p.Offset - start
+	}
+	body, serr := s.GetRange(ctx, key, start, length)
+	if serr != nil {
+		return fmt.Errorf("SimulateAt: %v", serr)
+	}
+	if body == nil {
+		return fmt.Errorf("SimulateAt: dump object '%s' not found", key)
+	}
+	defer func() {
+		serr := body.Close()
+		if serr != nil && err == nil {
+			err = fmt.Errorf("SimulateAt: %v", serr)
+		}
+	}()
+	d, serr := NewReader(body)
+	if serr != nil {
+		return fmt.Errorf("SimulateAt: %v", serr)
+	}
+	defer d.Close()
+	for {
+		l, serr := d.Read()
+		if serr == io.EOF {
+			return nil
+		}
+		if serr != nil {
+			return fmt.Errorf("SimulateAt: %v", serr)
+		}
+		if l.Timestamp > timestamp {
+			return nil
+		}
+		serr = Simulate(sim, l)
+		if serr != nil {
+			return fmt.Errorf("SimulateAt: %v", serr)
+		}
+	}
+}
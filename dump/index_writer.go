@@ -0,0 +1,122 @@
+package dump
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultIndexInterval is the default number of uncompressed bytes between seek points.
+const DefaultIndexInterval = 256 * 1024
+
+// countingWriter counts bytes written to know offsets of seek points.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// IndexedWriter writes lines to a dump file while building its Index.
+// A seek point is made at the start of every state block and every interval of bytes,
+// where a new gzip stream is started for gzip compressed dumps so that reading can start from there.
+type IndexedWriter struct {
+	cw       *countingWriter
+	gzip     bool
+	interval int64
+	// Writer of the current segment between seek points
+	w *Writer
+	// Uncompressed bytes written in the current segment
+	written int64
+	inState bool
+	index   *Index
+	buf     []byte
+}
+
+// NewIndexedWriter creates a new IndexedWriter, interval is the number of uncompressed bytes between seek points.
+// DefaultIndexInterval is used if interval is not positive.
+func NewIndexedWriter(w io.Writer, gzip bool, interval int64) *IndexedWriter {
+	d := new(IndexedWriter)
+	d.cw = &countingWriter{w: w}
+	d.gzip = gzip
+	if interval <= 0 {
+		interval = DefaultIndexInterval
+	}
+	d.interval = interval
+	d.index = new(Index)
+	return d
+}
+
+// seek finishes the current segment and starts a new one at the current offset.
+func (d *IndexedWriter) seek(timestamp int64) error {
+	if d.w != nil {
+		serr := d.w.Close()
+		if serr != nil {
+			return serr
+		}
+	}
+	if d.gzip {
+		d.w = NewGzipWriter(d.cw)
+	} else {
+		d.w = NewWriter(d.cw)
+	}
+	d.written = 0
+	d.index.SeekPoints = append(d.index.SeekPoints, SeekPoint{Timestamp: timestamp, Offset: d.cw.n})
+	return nil
+}
+
+// Write writes a line with trailing line feed.
+func (d *IndexedWriter) Write(l *Line) error {
+	state := l.Type == TypeState
+	if d.w == nil || (state && !d.inState) || (!d.inState && d.written >= d.interval) {
+		serr := d.seek(l.Timestamp)
+		if serr != nil {
+			return fmt.Errorf("Write: %v", serr)
+		}
+		if state {
+			d.index.StateBlocks = append(d.index.StateBlocks, StateBlock{
+				SeekPoint: d.index.SeekPoints[len(d.index.SeekPoints)-1],
+			})
+		}
+	}
+	d.inState = state
+	var serr error
+	d.buf, serr = AppendLine(d.buf[:0], l)
+	if serr != nil {
+		return fmt.Errorf("Write: %v", serr)
+	}
+	if state {
+		b := &d.index.StateBlocks[len(d.index.StateBlocks)-1]
+		b.Lines = append(b.Lines, StateLine{
+			Channel: l.Channel,
+			Offset:  d.written,
+			Length:  int64(len(d.buf)),
+		})
+	}
+	serr = d.w.Write(l)
+	if serr != nil {
+		return fmt.Errorf("Write: %v", serr)
+	}
+	d.written += int64(len(d.buf)) + 1
+	return nil
+}
+
+// Close finishes the dump file, it does not close the underlying writer.
+func (d *IndexedWriter) Close() error {
+	if d.w == nil {
+		return nil
+	}
+	serr := d.w.Close()
+	if serr != nil {
+		return fmt.Errorf("Close: %v", serr)
+	}
+	return nil
+}
+
+// Index returns the index of lines written so far, it is complete after Close.
+func (d *IndexedWriter) Index() *Index {
+	return d.index
+}
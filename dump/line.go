@@ -0,0 +1,107 @@
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/exchangedataset/streamcommons"
+)
+
+// Type of lines in dump files, the same as streamcommons.LineType*
+const (
+	// TypeStart is the line written when a connection is established, message is the URL connected to.
+	TypeStart = streamcommons.LineTypeStart
+	// TypeEnd is the line written when a connection is closed.
+	TypeEnd = streamcommons.LineTypeEnd
+	// TypeMessage is the line for a message received from the server.
+	TypeMessage = streamcommons.LineTypeMessage
+	// TypeSend is the line for a message sent to the server.
+	TypeSend = streamcommons.LineTypeSend
+	// TypeState is the line for a state snapshot taken by a simulator.
+	TypeState = streamcommons.LineTypeState
+	// TypeError is the line written when an error occurred.
+	TypeError = streamcommons.LineTypeError
+)
+
+// Line is a line in dump files.
+type Line struct {
+	Type string
+	// Timestamp in unix nanosecond
+	Timestamp int64
+	// Channel is empty for start, end and err lines
+	Channel string
+	// Message is nil for end lines
+	Message []byte
+}
+
+// hasChannel returns true if lines of the type have channel field.
+func hasChannel(typ string) bool {
+	return typ == TypeMessage || typ == TypeSend || typ == TypeState
+}
+
+// hasMessage returns true if lines of the type have message field.
+func hasMessage(typ string) bool {
+	return typ != TypeEnd
+}
+
+// ParseLine parses a line without trailing line feed.
+// Message of the returned line shares the memory with the given byte slice.
+func ParseLine(b []byte) (*Line, error) {
+	l := new(Line)
+	i := bytes.IndexByte(b, '\t')
+	if i == -1 {
+		return nil, errors.New("ParseLine: no type field")
+	}
+	l.Type = string(b[:i])
+	b = b[i+1:]
+	var timestampBytes []byte
+	if hasMessage(l.Type) {
+		i = bytes.IndexByte(b, '\t')
+		if i == -1 {
+			return nil, fmt.Errorf("ParseLine: no timestamp field for '%s' line", l.Type)
+		}
+		timestampBytes = b[:i]
+		b = b[i+1:]
+	} else {
+		timestampBytes = b
+		b = nil
+	}
+	timestamp, serr := strconv.ParseInt(string(timestampBytes), 10, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("ParseLine: timestamp: %v", serr)
+	}
+	l.Timestamp = timestamp
+	if hasChannel(l.Type) {
+		i = bytes.IndexByte(b, '\t')
+		if i == -1 {
+			return nil, fmt.Errorf("ParseLine: no channel field for '%s' line", l.Type)
+		}
+		l.Channel = string(b[:i])
+		b = b[i+1:]
+	}
+	if hasMessage(l.Type) {
+		l.Message = b
+	}
+	return l, nil
+}
+
+// AppendLine appends the serialized line to dst without trailing line feed and returns the extended slice.
+func AppendLine(dst []byte, l *Line) ([]byte, error) {
+	if l.Type == "" {
+		return nil, errors.New("AppendLine: empty type")
+	}
+	dst = append(dst, l.Type...)
+	dst = append(dst, '\t')
+	dst = strconv.AppendInt(dst, l.Timestamp, 10)
+	if hasChannel(l.Type) {
+		dst = append(dst, '\t')
+		dst = append(dst, l.Channel...)
+	}
+	if hasMessage(l.Type) {
+		dst = append(dst, '\t')
+		dst = append(dst, l.Message...)
+	}
+	return dst, nil
+}
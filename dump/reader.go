@@ -0,0 +1,80 @@
+package dump
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// gzipMagic is the first two bytes of gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Reader reads lines from a dump file in streaming manner.
+type Reader struct {
+	br *bufio.Reader
+	gr *gzip.Reader
+	// Line number of the last line read, starts from 1
+	n int
+}
+
+// NewReader creates a new Reader. If r is gzip compressed, it is decompressed transparently.
+func NewReader(r io.Reader) (*Reader, error) {
+	d := new(Reader)
+	br := bufio.NewReaderSize(r, 64*1024)
+	magic, serr := br.Peek(len(gzipMagic))
+	if serr != nil && serr != io.EOF {
+		return nil, fmt.Errorf("NewReader: peek: %v", serr)
+	}
+	if len(magic) == len(gzipMagic) && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		gr, serr := gzip.NewReader(br)
+		if serr != nil {
+			return nil, fmt.Errorf("NewReader: gzip: %v", serr)
+		}
+		d.gr = gr
+		d.br = bufio.NewReaderSize(gr, 64*1024)
+	} else {
+		d.br = br
+	}
+	return d, nil
+}
+
+// Read reads the next line. It returns io.EOF if there are no more lines.
+// Returned line is valid until the next call.
+func (d *Reader) Read() (*Line, error) {
+	b, serr := d.br.ReadBytes('\n')
+	if serr != nil {
+		if serr != io.EOF {
+			return nil, fmt.Errorf("Read: %v", serr)
+		}
+		if len(b) == 0 {
+			return nil, io.EOF
+		}
+		// Last line without line feed
+	} else {
+		b = b[:len(b)-1]
+	}
+	d.n++
+	l, serr := ParseLine(b)
+	if serr != nil {
+		return nil, fmt.Errorf("Read: line %d: %v", d.n, serr)
+	}
+	return l, nil
+}
+
+// ReadLine is the same as Read but returns each field, this implements simulator.LineReader.
+func (d *Reader) ReadLine() (typ string, timestamp int64, channel string, message []byte, err error) {
+	l, err := d.Read()
+	if err != nil {
+		return
+	}
+	return l.Type, l.Timestamp, l.Channel, l.Message, nil
+}
+
+// Close frees resources associated with this reader. It does not close the underlying reader.
+func (d *Reader) Close() error {
+	if d.gr != nil {
+		return d.gr.Close()
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package dump
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Writer writes lines to a dump file.
+type Writer struct {
+	bw  *bufio.Writer
+	gw  *gzip.Writer
+	buf []byte
+}
+
+// NewWriter creates a new Writer which writes plain text lines to w.
+func NewWriter(w io.Writer) *Writer {
+	d := new(Writer)
+	d.bw = bufio.NewWriterSize(w, 64*1024)
+	return d
+}
+
+// NewGzipWriter creates a new Writer which writes gzip compressed lines to w.
+func NewGzipWriter(w io.Writer) *Writer {
+	d := new(Writer)
+	d.gw = gzip.NewWriter(w)
+	d.bw = bufio.NewWriterSize(d.gw, 64*1024)
+	return d
+}
+
+// Write writes a line with trailing line feed.
+func (d *Writer) Write(l *Line) (err error) {
+	d.buf, err = AppendLine(d.buf[:0], l)
+	if err != nil {
+		return fmt.Errorf("Write: %v", err)
+	}
+	d.buf = append(d.buf, '\n')
+	_, err = d.bw.Write(d.buf)
+	if err != nil {
+		return fmt.Errorf("Write: %v", err)
+	}
+	return nil
+}
+
+// Flush writes buffered lines to the underlying writer.
+func (d *Writer) Flush() error {
+	serr := d.bw.Flush()
+	if serr != nil {
+		return fmt.Errorf("Flush: %v", serr)
+	}
+	if d.gw != nil {
+		serr = d.gw.Flush()
+		if serr != nil {
+			return fmt.Errorf("Flush: gzip: %v", serr)
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered lines and finishes gzip stream if any.
+// It does not close the underlying writer.
+func (d *Writer) Close() error {
+	serr := d.bw.Flush()
+	if serr != nil {
+		return fmt.Errorf("Close: %v", serr)
+	}
+	if d.gw != nil {
+		serr = d.gw.Close()
+		if serr != nil {
+			return fmt.Errorf("Close: gzip: %v", serr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedataset/streamcommons"
+	"github.com/exchangedataset/streamcommons/formatter/jsondef"
+	"github.com/exchangedataset/streamcommons/jsonstructs"
+)
+
+func bitbankFormatTimestamp(millisec int64) string {
+	return strconv.FormatInt(millisec*int64(time.Millisecond), 10)
+}
+
+// bitbankFormatter formats messages from Bitbank to json format.
+type bitbankFormatter struct {
+	// Bitbank does not respond to join-room, so typedef is returned on the first message of a channel.
+	typedefSent map[string]bool
+}
+
+// FormatStart returns empty slice.
+func (f *bitbankFormatter) FormatStart(urlStr string) ([]Result, error) {
+	return make([]Result, 0), nil
+}
+
+func (f *bitbankFormatter) typedef(channel string) ([]byte, error) {
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTicker) {
+		return jsondef.TypeDefBitbankTicker, nil
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTransactions) {
+		return jsondef.TypeDefBitbankTransactions, nil
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
+		return jsondef.TypeDefBitbankDepthWhole, nil
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff) {
+		return jsondef.TypeDefBitbankDepthDiff, nil
+	}
+	return nil, fmt.Errorf("channel not supported: %s", channel)
+}
+
+func (f *bitbankFormatter) formatTicker(channel string, data json.RawMessage) ([]Result, error) {
+	symbol := channel[len(streamcommons.BitbankChannelPrefixTicker):]
+	ticker := new(jsonstructs.BitbankTicker)
+	serr := json.Unmarshal(data, ticker)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: BitbankTicker: %v", serr)
+	}
+	ft := new(jsondef.BitbankTicker)
+	ft.Symbol = symbol
+	ft.Timestamp = bitbankFormatTimestamp(ticker.Timestamp)
+	ft.Sell, serr = strconv.ParseFloat(ticker.Sell, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: sell: %v", serr)
+	}
+	ft.Buy, serr = strconv.ParseFloat(ticker.Buy, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: buy: %v", serr)
+	}
+	ft.High, serr = strconv.ParseFloat(ticker.High, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: high: %v", serr)
+	}
+	ft.Low, serr = strconv.ParseFloat(ticker.Low, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: low: %v", serr)
+	}
+	ft.Last, serr = strconv.ParseFloat(ticker.Last, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: last: %v", serr)
+	}
+	ft.Volume, serr = strconv.ParseFloat(ticker.Volume, 64)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: volume: %v", serr)
+	}
+	marshaled, serr := json.Marshal(ft)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTicker: marshal: %v", serr)
+	}
+	return []Result{
+		Result{
+			Channel: channel,
+			Message: marshaled,
+		},
+	}, nil
+}
+
+func (f *bitbankFormatter) formatTransactions(channel string, data json.RawMessage) ([]Result, error) {
+	symbol := channel[len(streamcommons.BitbankChannelPrefixTransactions):]
+	transactions := new(jsonstructs.BitbankTransactionsData)
+	serr := json.Unmarshal(data, transactions)
+	if serr != nil {
+		return nil, fmt.Errorf("formatTransactions: BitbankTransactionsData: %v", serr)
+	}
+	ret := make([]Result, len(transactions.Transactions))
+	for i, tx := range transactions.Transactions {
+		ft := new(jsondef.BitbankTransactions)
+		ft.Symbol = symbol
+		ft.TransactionID = int64(tx.TransactionID)
+		ft.Timestamp = bitbankFormatTimestamp(tx.ExecutedAt)
+		ft.Price, serr = strconv.ParseFloat(tx.Price, 64)
+		if serr != nil {
+			return nil, fmt.Errorf("formatTransactions: price: %v", serr)
+		}
+		ft.Size, serr = strconv.ParseFloat(tx.Amount, 64)
+		if serr != nil {
+			return nil, fmt.Errorf("formatTransactions: amount: %v", serr)
+		}
+		if tx.Side == "sell" {
+			ft.Side = streamcommons.CommonFormatSell
+		} else if tx.Side == "buy" {
+			ft.Side = streamcommons.CommonFormatBuy
+		} else {
+			ft.Side = streamcommons.CommonFormatUnknown
+		}
+		marshaled, serr := json.Marshal(ft)
+		if serr != nil {
+			return nil, fmt.Errorf("formatTransactions: marshal: %v", serr)
+		}
+		ret[i] = Result{
+			Channel: channel,
+			Message: marshaled,
+		}
+	}
+	return ret, nil
+}
+
+// formatDepthSide formats orders on one side of the depth and appends them to ret.
+// newDef builds a jsondef struct for an order.
+func (f *bitbankFormatter) formatDepthSide(channel string, orders [][]string, side string, ret []Result, newDef func(price float64, side string, size float64) interface{}) ([]Result, error) {
+	for _, order := range orders {
+		if len(order) < 2 {
+			return nil, errors.New("order has less than 2 elements")
+		}
+		price, serr := strconv.ParseFloat(order[0], 64)
+		if serr != nil {
+			return nil, fmt.Errorf("price: %v", serr)
+		}
+		// size == 0 if due to be removed
+		size, serr := strconv.ParseFloat(order[1], 64)
+		if serr != nil {
+			return nil, fmt.Errorf("size: %v", serr)
+		}
+		marshaled, serr := json.Marshal(newDef(price, side, size))
+		if serr != nil {
+			return nil, fmt.Errorf("marshal: %v", serr)
+		}
+		ret = append(ret, Result{
+			Channel: channel,
+			Message: marshaled,
+		})
+	}
+	return ret, nil
+}
+
+func (f *bitbankFormatter) formatDepthWhole(channel string, data json.RawMessage) (ret []Result, err error) {
+	symbol := channel[len(streamcommons.BitbankChannelPrefixDepthWhole):]
+	depth := new(jsonstructs.BitbankDepthWhole)
+	serr := json.Unmarshal(data, depth)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthWhole: BitbankDepthWhole: %v", serr)
+	}
+	timestamp := bitbankFormatTimestamp(depth.Timestamp)
+	newDef := func(price float64, side string, size float64) interface{} {
+		return &jsondef.BitbankDepthWhole{
+			Symbol:    symbol,
+			Timestamp: timestamp,
+			Price:     price,
+			Side:      side,
+			Size:      size,
+		}
+	}
+	ret = make([]Result, 0, len(depth.Asks)+len(depth.Bids))
+	ret, serr = f.formatDepthSide(channel, depth.Asks, streamcommons.CommonFormatSell, ret, newDef)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthWhole: ask: %v", serr)
+	}
+	ret, serr = f.formatDepthSide(channel, depth.Bids, streamcommons.CommonFormatBuy, ret, newDef)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthWhole: bid: %v", serr)
+	}
+	return
+}
+
+func (f *bitbankFormatter) formatDepthDiff(channel string, data json.RawMessage) (ret []Result, err error) {
+	symbol := channel[len(streamcommons.BitbankChannelPrefixDepthDiff):]
+	depth := new(jsonstructs.BitbankDepthDiff)
+	serr := json.Unmarshal(data, depth)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthDiff: BitbankDepthDiff: %v", serr)
+	}
+	timestamp := bitbankFormatTimestamp(depth.Timestamp)
+	newDef := func(price float64, side string, size float64) interface{} {
+		return &jsondef.BitbankDepthDiff{
+			Symbol:    symbol,
+			Timestamp: timestamp,
+			Price:     price,
+			Side:      side,
+			Size:      size,
+		}
+	}
+	ret = make([]Result, 0, len(depth.Asks)+len(depth.Bids))
+	ret, serr = f.formatDepthSide(channel, depth.Asks, streamcommons.CommonFormatSell, ret, newDef)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthDiff: ask: %v", serr)
+	}
+	ret, serr = f.formatDepthSide(channel, depth.Bids, streamcommons.CommonFormatBuy, ret, newDef)
+	if serr != nil {
+		return nil, fmt.Errorf("formatDepthDiff: bid: %v", serr)
+	}
+	return
+}
+
+// FormatMessage formats raw messages from Bitbank server to json format.
+func (f *bitbankFormatter) FormatMessage(channel string, line []byte) (formatted []Result, err error) {
+	if !bytes.HasPrefix(line, []byte("42")) {
+		// Socket.io control messages does not have to be formatted
+		return nil, nil
+	}
+	wrapper := new(jsonstructs.BitbankWrapper)
+	serr := json.Unmarshal(line[2:], wrapper)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatMessage: wrapper: %v", serr)
+	}
+	root := new(jsonstructs.BitbankRoot)
+	serr = json.Unmarshal(wrapper[1], root)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatMessage: root: %v", serr)
+	}
+	msg := new(jsonstructs.BitbankMessage)
+	serr = json.Unmarshal(root.Message, msg)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatMessage: message: %v", serr)
+	}
+	if !f.typedefSent[channel] {
+		typedef, serr := f.typedef(channel)
+		if serr != nil {
+			return nil, fmt.Errorf("FormatMessage: %v", serr)
+		}
+		formatted = append(formatted, Result{
+			Channel: channel,
+			Message: typedef,
+		})
+		f.typedefSent[channel] = true
+	}
+	var sret []Result
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTicker) {
+		sret, err = f.formatTicker(channel, msg.Data)
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTransactions) {
+		sret, err = f.formatTransactions(channel, msg.Data)
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
+		sret, err = f.formatDepthWhole(channel, msg.Data)
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff) {
+		sret, err = f.formatDepthDiff(channel, msg.Data)
+	} else {
+		err = fmt.Errorf("FormatMessage: channel not supported: %s", channel)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(formatted, sret...), nil
+}
+
+// IsSupported returns true if the given channel is supported by this formatter.
+func (f *bitbankFormatter) IsSupported(channel string) bool {
+	return strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTicker) ||
+		strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixTransactions) ||
+		strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) ||
+		strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff)
+}
+
+func newBitbankFormatter() *bitbankFormatter {
+	f := new(bitbankFormatter)
+	f.typedefSent = make(map[string]bool)
+	return f
+}
@@ -21,6 +21,17 @@ func bitflyerParseTimestamp(timestamp string) (string, error) {
 	return strconv.FormatInt(timestampTime.UnixNano(), 10), nil
 }
 
+// bitflyerSide converts the side of an execution into the common format.
+func bitflyerSide(side string) string {
+	if side == "ask" || side == "SELL" {
+		return streamcommons.CommonFormatSell
+	} else if side == "bid" || side == "BUY" {
+		return streamcommons.CommonFormatBuy
+	}
+	// for some reason, side could be empty, probably a bug of bitflyer api
+	return streamcommons.CommonFormatUnknown
+}
+
 // bitflyerFormatter formats raw input from bitflyer api into csvlike format.
 type bitflyerFormatter struct {
 }
@@ -92,19 +103,10 @@ func (f *bitflyerFormatter) formatExecutions(channel string, messageRaw json.Raw
 	}
 	ret := make([]Result, len(orders))
 	for i, element := range orders {
-		var side string
-		if element.Side == "ask" || element.Side == "SELL" {
-			side = streamcommons.CommonFormatSell
-		} else if element.Side == "bid" || element.Side == "BUY" {
-			side = streamcommons.CommonFormatBuy
-		} else {
-			// for some reason, side could be empty, probably a bug of bitflyer api
-			side = streamcommons.CommonFormatUnknown
-		}
 		marshaled, serr := json.Marshal(jsondef.BitflyerExecutions{
 			Symbol: pair,
 			Price:  element.Price,
-			Side:   side,
+			Side:   bitflyerSide(element.Side),
 			Size:   element.Size,
 		})
 		if serr != nil {
@@ -0,0 +1,158 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/exchangedataset/streamcommons/formatter/jsondef"
+)
+
+// csvFormatter converts results of the json formatter into csv rows.
+// Typedefs become header rows and records become rows in the same column order.
+type csvFormatter struct {
+	json Formatter
+	// Column names of the header sent last for each channel
+	columns map[string][]string
+}
+
+// jsonObjectFields decodes a json object preserving the order of the keys.
+func jsonObjectFields(message []byte) (keys []string, values []json.RawMessage, err error) {
+	dec := json.NewDecoder(bytes.NewReader(message))
+	tok, serr := dec.Token()
+	if serr != nil {
+		return nil, nil, serr
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("not a json object")
+	}
+	for dec.More() {
+		tok, serr = dec.Token()
+		if serr != nil {
+			return nil, nil, serr
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, nil, errors.New("key is not a string")
+		}
+		var value json.RawMessage
+		serr = dec.Decode(&value)
+		if serr != nil {
+			return nil, nil, serr
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// csvCell converts a json value into a csv cell.
+// Null becomes an empty cell and strings are unquoted, others are written as they are.
+func csvCell(value json.RawMessage) (string, error) {
+	if len(value) == 0 || bytes.Equal(value, []byte("null")) {
+		return "", nil
+	}
+	if value[0] == '"' {
+		var str string
+		serr := json.Unmarshal(value, &str)
+		if serr != nil {
+			return "", serr
+		}
+		return str, nil
+	}
+	return string(value), nil
+}
+
+// csvRow encodes cells into a csv row without trailing line feed.
+func csvRow(cells []string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	serr := w.Write(cells)
+	if serr != nil {
+		return nil, serr
+	}
+	w.Flush()
+	serr = w.Error()
+	if serr != nil {
+		return nil, serr
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (f *csvFormatter) convert(results []Result) ([]Result, error) {
+	ret := make([]Result, len(results))
+	for i, r := range results {
+		keys, values, serr := jsonObjectFields(r.Message)
+		if serr != nil {
+			return nil, fmt.Errorf("channel '%s': %v", r.Channel, serr)
+		}
+		if jsondef.IsTypeDef(r.Message) {
+			f.columns[r.Channel] = keys
+			row, serr := csvRow(keys)
+			if serr != nil {
+				return nil, fmt.Errorf("channel '%s': %v", r.Channel, serr)
+			}
+			ret[i] = Result{Channel: r.Channel, Message: row}
+			continue
+		}
+		columns, ok := f.columns[r.Channel]
+		if !ok {
+			// Header is not known, use the order of the record itself
+			columns = keys
+		}
+		fields := make(map[string]json.RawMessage, len(keys))
+		for j, key := range keys {
+			fields[key] = values[j]
+		}
+		cells := make([]string, len(columns))
+		for j, column := range columns {
+			cells[j], serr = csvCell(fields[column])
+			if serr != nil {
+				return nil, fmt.Errorf("channel '%s': column '%s': %v", r.Channel, column, serr)
+			}
+		}
+		row, serr := csvRow(cells)
+		if serr != nil {
+			return nil, fmt.Errorf("channel '%s': %v", r.Channel, serr)
+		}
+		ret[i] = Result{Channel: r.Channel, Message: row}
+	}
+	return ret, nil
+}
+
+func (f *csvFormatter) FormatStart(urlStr string) ([]Result, error) {
+	results, serr := f.json.FormatStart(urlStr)
+	if serr != nil {
+		return nil, serr
+	}
+	ret, serr := f.convert(results)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatStart: %v", serr)
+	}
+	return ret, nil
+}
+
+func (f *csvFormatter) FormatMessage(channel string, line []byte) ([]Result, error) {
+	results, serr := f.json.FormatMessage(channel, line)
+	if serr != nil {
+		return nil, serr
+	}
+	ret, serr := f.convert(results)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatMessage: %v", serr)
+	}
+	return ret, nil
+}
+
+func (f *csvFormatter) IsSupported(channel string) bool {
+	return f.json.IsSupported(channel)
+}
+
+func newCSVFormatter(json Formatter) *csvFormatter {
+	f := new(csvFormatter)
+	f.json = json
+	f.columns = make(map[string][]string)
+	return f
+}
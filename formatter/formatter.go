@@ -19,25 +19,57 @@ type Formatter interface {
 	IsSupported(channel string) bool
 }
 
+// TimestampFormatter is a Formatter which uses the time a line was received in unix nanosecond
+// for records of messages without timestamp.
+type TimestampFormatter interface {
+	Formatter
+	FormatMessageAt(channel string, timestamp int64, line []byte) ([]Result, error)
+}
+
+// getJSONFormatter returns the json formatter for the exchange.
+func getJSONFormatter(exchange string, channels []string, format string) (Formatter, error) {
+	switch exchange {
+	case streamcommons.ExchangeBinance:
+		return newBinanceFormatter(), nil
+	case streamcommons.ExchangeBitflyer:
+		return newBitflyerFormatter(), nil
+	case streamcommons.ExchangeBitmex:
+		return newBitmexFormatter(channels), nil
+	case streamcommons.ExchangeLiquid:
+		return newLiquidFormatter(), nil
+	case streamcommons.ExchangeBitfinex:
+		return newBitfinexFormatter(), nil
+	case streamcommons.ExchangeBitbank:
+		return newBitbankFormatter(), nil
+	default:
+		return nil, fmt.Errorf("format '%s' is not supported for exchange '%s'", format, exchange)
+	}
+}
+
 // GetFormatter returns the right formatter for given parameters.
+// Supported formats are "json", "csv" and "normalized", csv rows follow the column order of typedefs and
+// normalized format converts trades and orderbooks of all exchanges into jsondef.Trade and jsondef.BookLevelUpdate.
 func GetFormatter(exchange string, channels []string, format string) (Formatter, error) {
 	var f Formatter
 	switch format {
 	case "json":
-		switch exchange {
-		case streamcommons.ExchangeBinance:
-			f = newBinanceFormatter()
-		case streamcommons.ExchangeBitflyer:
-			f = newBitflyerFormatter()
-		case streamcommons.ExchangeBitmex:
-			f = newBitmexFormatter(channels)
-		case streamcommons.ExchangeLiquid:
-			f = newLiquidFormatter()
-		case streamcommons.ExchangeBitfinex:
-			f = newBitfinexFormatter()
-		default:
-			return nil, fmt.Errorf("format '%s' is not supported for exchange '%s'", format, exchange)
+		jf, serr := getJSONFormatter(exchange, channels, format)
+		if serr != nil {
+			return nil, serr
+		}
+		f = jf
+	case "csv":
+		jf, serr := getJSONFormatter(exchange, channels, format)
+		if serr != nil {
+			return nil, serr
+		}
+		f = newCSVFormatter(jf)
+	case "normalized":
+		jf, serr := getJSONFormatter(exchange, channels, format)
+		if serr != nil {
+			return nil, serr
 		}
+		f = newNormalizedFormatter(exchange, jf)
 	default:
 		return nil, fmt.Errorf("format '%s' is not supported", format)
 	}
@@ -0,0 +1,53 @@
+package jsondef
+
+// BitbankTicker is auto-generated
+type BitbankTicker struct {
+	Symbol    string  `json:"symbol"`
+	Timestamp string  `json:"timestamp"`
+	Sell      float64 `json:"sell"`
+	Buy       float64 `json:"buy"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Last      float64 `json:"last"`
+	Volume    float64 `json:"volume"`
+}
+
+// TypeDefBitbankTicker is auto-generated
+var TypeDefBitbankTicker = []byte("{\"symbol\": \"symbol\", \"timestamp\": \"timestamp\", \"sell\": \"float\", \"buy\": \"float\", \"high\": \"float\", \"low\": \"float\", \"last\": \"float\", \"volume\": \"float\"}")
+
+// BitbankTransactions is auto-generated
+type BitbankTransactions struct {
+	Symbol        string  `json:"symbol"`
+	TransactionID int64   `json:"transactionId"`
+	Price         float64 `json:"price"`
+	Timestamp     string  `json:"timestamp"`
+	Side          string  `json:"side"`
+	Size          float64 `json:"size"`
+}
+
+// TypeDefBitbankTransactions is auto-generated
+var TypeDefBitbankTransactions = []byte("{\"symbol\": \"symbol\", \"transactionId\": \"int\", \"price\": \"price\", \"timestamp\": \"timestamp\", \"side\": \"side\", \"size\": \"size\"}")
+
+// BitbankDepthWhole is auto-generated
+type BitbankDepthWhole struct {
+	Symbol    string  `json:"symbol"`
+	Timestamp string  `json:"timestamp"`
+	Price     float64 `json:"price"`
+	Side      string  `json:"side"`
+	Size      float64 `json:"size"`
+}
+
+// TypeDefBitbankDepthWhole is auto-generated
+var TypeDefBitbankDepthWhole = []byte("{\"symbol\": \"symbol\", \"timestamp\": \"timestamp\", \"price\": \"price\", \"side\": \"side\", \"size\": \"size\"}")
+
+// BitbankDepthDiff is auto-generated
+type BitbankDepthDiff struct {
+	Symbol    string  `json:"symbol"`
+	Timestamp string  `json:"timestamp"`
+	Price     float64 `json:"price"`
+	Side      string  `json:"side"`
+	Size      float64 `json:"size"`
+}
+
+// TypeDefBitbankDepthDiff is auto-generated
+var TypeDefBitbankDepthDiff = []byte("{\"symbol\": \"symbol\", \"timestamp\": \"timestamp\", \"price\": \"price\", \"side\": \"side\", \"size\": \"size\"}")
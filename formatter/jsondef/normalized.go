@@ -0,0 +1,35 @@
+package jsondef
+
+// Trade is the trade normalized across exchanges.
+// Timestamp is in unix nanosecond, it is the time the line was received if the exchange does not provide it.
+// TradeID is null if the exchange does not provide it.
+type Trade struct {
+	Exchange  string  `json:"exchange"`
+	Symbol    string  `json:"symbol"`
+	Timestamp int64   `json:"timestamp,string"`
+	Side      string  `json:"side"`
+	Price     float64 `json:"price"`
+	Size      float64 `json:"size"`
+	TradeID   *string `json:"tradeId"`
+}
+
+// TypeDefTrade is the typedef for Trade
+var TypeDefTrade = []byte("{\"exchange\": \"string\", \"symbol\": \"symbol\", \"timestamp\": \"timestamp\", \"side\": \"side\", \"price\": \"price\", \"size\": \"size\", \"tradeId\": \"string\"}")
+
+// BookLevelUpdate is the update of a price level in orderbook normalized across exchanges.
+// Size is 0 if the level is removed.
+// Timestamp is in unix nanosecond, it is the time the line was received if the exchange does not provide it.
+// IsSnapshot is true for levels of a message which replaces all levels of the channel,
+// levels of the channel which are not in the same message should be removed.
+type BookLevelUpdate struct {
+	Exchange   string  `json:"exchange"`
+	Symbol     string  `json:"symbol"`
+	Timestamp  int64   `json:"timestamp,string"`
+	Side       string  `json:"side"`
+	Price      float64 `json:"price"`
+	Size       float64 `json:"size"`
+	IsSnapshot bool    `json:"isSnapshot"`
+}
+
+// TypeDefBookLevelUpdate is the typedef for BookLevelUpdate
+var TypeDefBookLevelUpdate = []byte("{\"exchange\": \"string\", \"symbol\": \"symbol\", \"timestamp\": \"timestamp\", \"side\": \"side\", \"price\": \"price\", \"size\": \"size\", \"isSnapshot\": \"boolean\"}")
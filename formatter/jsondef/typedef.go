@@ -0,0 +1,52 @@
+package jsondef
+
+import (
+	"bytes"
+	"reflect"
+)
+
+// typeDefs is the list of all typedefs and the struct of records they describe.
+var typeDefs = []struct {
+	typeDef []byte
+	record  interface{}
+}{
+	{TypeDefBinanceDepth, BinanceDepth{}},
+	{TypeDefBinanceRestDepth, BinanceRestDepth{}},
+	{TypeDefBinanceTrade, BinanceTrade{}},
+	{TypeDefBinanceTicker, BinanceTicker{}},
+	{TypeDefBitbankTicker, BitbankTicker{}},
+	{TypeDefBitbankTransactions, BitbankTransactions{}},
+	{TypeDefBitbankDepthWhole, BitbankDepthWhole{}},
+	{TypeDefBitbankDepthDiff, BitbankDepthDiff{}},
+	{TypeDefBitfinexBook, BitfinexBook{}},
+	{TypeDefBitfinexTrades, BitfinexTrades{}},
+	{TypeDefBitflyerBoard, BitflyerBoard{}},
+	{TypeDefBitflyerExecutions, BitflyerExecutions{}},
+	{TypeDefBitflyerTicker, BitflyerTicker{}},
+	{TypeDefBitmexOrderBookL2, BitmexOrderBookL2{}},
+	{TypeDefBitmexTrade, BitmexTrade{}},
+	{TypeDefBitmexInstrument, BitmexInstrument{}},
+	{TypeDefBitmexInsurance, BitmexInsurance{}},
+	{TypeDefBitmexFunding, BitmexFunding{}},
+	{TypeDefBitmexSettlement, BitmexSettlement{}},
+	{TypeDefBitmexLiquidation, BitmexLiquidation{}},
+	{TypeDefLiquidExecutionsCash, LiquidExecutionsCash{}},
+	{TypeDefLiquidPriceLaddersCash, LiquidPriceLaddersCash{}},
+	{TypeDefTrade, Trade{}},
+	{TypeDefBookLevelUpdate, BookLevelUpdate{}},
+}
+
+// IsTypeDef returns true if the message is one of typedefs.
+func IsTypeDef(message []byte) bool {
+	return RecordType(message) != nil
+}
+
+// RecordType returns the struct type of records described by the typedef, nil if the message is not a typedef.
+func RecordType(typeDef []byte) reflect.Type {
+	for _, def := range typeDefs {
+		if bytes.Equal(typeDef, def.typeDef) {
+			return reflect.TypeOf(def.record)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exchangedataset/streamcommons"
+	"github.com/exchangedataset/streamcommons/formatter/jsondef"
+	"github.com/exchangedataset/streamcommons/jsonstructs"
+)
+
+// normalizedFormatter converts results of the json formatter of an exchange into
+// jsondef.Trade and jsondef.BookLevelUpdate which are common to all exchanges.
+// Only trade and orderbook channels are supported.
+type normalizedFormatter struct {
+	exchange string
+	json     Formatter
+}
+
+// optionalString returns nil for an empty string.
+func optionalString(str string) *string {
+	if str == "" {
+		return nil
+	}
+	return &str
+}
+
+func optionalInt(i int64) *string {
+	str := strconv.FormatInt(i, 10)
+	return &str
+}
+
+// parseTimestamp parses a timestamp in unix nanosecond formatted by the json formatter,
+// returns fallback if it is empty.
+func parseTimestamp(str string, fallback int64) (int64, error) {
+	if str == "" {
+		return fallback, nil
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func (f *normalizedFormatter) channelGroup(channel string) (streamcommons.ChannelGroup, error) {
+	cg, serr := streamcommons.GetChannelGroup(f.exchange, channel)
+	if serr != nil {
+		return cg, serr
+	}
+	if cg != streamcommons.ChannelGroupTrade && cg != streamcommons.ChannelGroupOrderbook {
+		return cg, fmt.Errorf("channel '%s' is neither trade nor orderbook", channel)
+	}
+	return cg, nil
+}
+
+// normalizeTrade normalizes a trade formatted by the json formatter.
+// timestamp is used if the trade does not have one.
+func (f *normalizedFormatter) normalizeTrade(message []byte, timestamp int64) (t *jsondef.Trade, err error) {
+	t = &jsondef.Trade{Exchange: f.exchange}
+	var ts string
+	switch f.exchange {
+	case streamcommons.ExchangeBitmex:
+		src := new(jsondef.BitmexTrade)
+		err = json.Unmarshal(message, src)
+		t.Symbol, t.Side, t.Price, t.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.Timestamp
+		t.TradeID = optionalString(src.TrdMatchID)
+	case streamcommons.ExchangeBinance:
+		src := new(jsondef.BinanceTrade)
+		err = json.Unmarshal(message, src)
+		t.Symbol, t.Side, t.Price, t.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.Timestamp
+		t.TradeID = optionalInt(src.TradeID)
+	case streamcommons.ExchangeLiquid:
+		src := new(jsondef.LiquidExecutionsCash)
+		err = json.Unmarshal(message, src)
+		t.Symbol, t.Side, t.Price, t.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.CreatedAt
+		t.TradeID = optionalInt(src.ID)
+	case streamcommons.ExchangeBitfinex:
+		// OrderID of bitfinex trades is actually the trade id
+		src := new(jsondef.BitfinexTrades)
+		err = json.Unmarshal(message, src)
+		t.Symbol, t.Side, t.Price, t.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.Timestamp
+		t.TradeID = optionalInt(src.OrderID)
+	case streamcommons.ExchangeBitbank:
+		src := new(jsondef.BitbankTransactions)
+		err = json.Unmarshal(message, src)
+		t.Symbol, t.Side, t.Price, t.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.Timestamp
+		t.TradeID = optionalInt(src.TransactionID)
+	default:
+		// Bitflyer executions are normalized from raw messages by normalizeBitflyerExecutions
+		err = fmt.Errorf("exchange '%s' is not supported", f.exchange)
+	}
+	if err == nil {
+		t.Timestamp, err = parseTimestamp(ts, timestamp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("normalizeTrade: %v", err)
+	}
+	return
+}
+
+// isSnapshot returns true if messages of the orderbook channel replace all levels of the channel
+// instead of updating some of them.
+func (f *normalizedFormatter) isSnapshot(channel string) bool {
+	switch f.exchange {
+	case streamcommons.ExchangeBinance:
+		_, stream, serr := streamcommons.BinanceDecomposeChannel(channel)
+		return serr == nil && stream == streamcommons.BinanceStreamRESTDepth
+	case streamcommons.ExchangeBitflyer:
+		return strings.HasPrefix(channel, streamcommons.BitflyerchannelPrefixLightningBoardSnapshot)
+	case streamcommons.ExchangeLiquid:
+		// Price ladders are sent as a whole for each side, which is a channel
+		return strings.HasPrefix(channel, streamcommons.LiquidChannelPrefixLaddersCash)
+	case streamcommons.ExchangeBitbank:
+		return strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole)
+	default:
+		return false
+	}
+}
+
+// normalizeBook normalizes an orderbook update formatted by the json formatter.
+// timestamp is used if the update does not have one, which is the case for most exchanges.
+func (f *normalizedFormatter) normalizeBook(channel string, message []byte, timestamp int64) (u *jsondef.BookLevelUpdate, err error) {
+	u = &jsondef.BookLevelUpdate{Exchange: f.exchange, IsSnapshot: f.isSnapshot(channel)}
+	var ts string
+	switch f.exchange {
+	case streamcommons.ExchangeBitmex:
+		src := new(jsondef.BitmexOrderBookL2)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+	case streamcommons.ExchangeBinance:
+		// BinanceRestDepth does not have eventTime
+		src := new(jsondef.BinanceDepth)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.EventTime
+	case streamcommons.ExchangeBitflyer:
+		src := new(jsondef.BitflyerBoard)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+	case streamcommons.ExchangeLiquid:
+		src := new(jsondef.LiquidPriceLaddersCash)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+	case streamcommons.ExchangeBitfinex:
+		src := new(jsondef.BitfinexBook)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+	case streamcommons.ExchangeBitbank:
+		// BitbankDepthWhole and BitbankDepthDiff have the same fields
+		src := new(jsondef.BitbankDepthDiff)
+		err = json.Unmarshal(message, src)
+		u.Symbol, u.Side, u.Price, u.Size = src.Symbol, src.Side, src.Price, src.Size
+		ts = src.Timestamp
+	default:
+		err = fmt.Errorf("exchange '%s' is not supported", f.exchange)
+	}
+	if err == nil {
+		u.Timestamp, err = parseTimestamp(ts, timestamp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("normalizeBook: %v", err)
+	}
+	return
+}
+
+// normalizeBitflyerExecutions normalizes raw messages of bitflyer executions,
+// because the json format of them does not have the timestamp nor the id.
+func (f *normalizedFormatter) normalizeBitflyerExecutions(channel string, line []byte) ([]Result, error) {
+	subscribed := new(jsonstructs.BitflyerSubscribed)
+	serr := json.Unmarshal(line, subscribed)
+	if serr != nil {
+		return nil, fmt.Errorf("normalizeBitflyerExecutions: %v", serr)
+	}
+	if subscribed.Result {
+		return []Result{Result{Channel: channel, Message: jsondef.TypeDefTrade}}, nil
+	}
+	root := new(jsonstructs.BitflyerRoot)
+	serr = json.Unmarshal(line, root)
+	if serr != nil {
+		return nil, fmt.Errorf("normalizeBitflyerExecutions: %v", serr)
+	}
+	elements := make([]jsonstructs.BitflyerExecutionsParamMessageElement, 0, 10)
+	serr = json.Unmarshal(root.Params.Message, &elements)
+	if serr != nil {
+		return nil, fmt.Errorf("normalizeBitflyerExecutions: %v", serr)
+	}
+	pair := channel[len(streamcommons.BitflyerChannelPrefixLightningExecutions):]
+	ret := make([]Result, len(elements))
+	for i, element := range elements {
+		execDate, serr := time.Parse(time.RFC3339Nano, element.ExecDate)
+		if serr != nil {
+			return nil, fmt.Errorf("normalizeBitflyerExecutions: exec_date: %v", serr)
+		}
+		tradeID := strconv.FormatUint(element.ID, 10)
+		marshaled, serr := json.Marshal(jsondef.Trade{
+			Exchange:  f.exchange,
+			Symbol:    pair,
+			Timestamp: execDate.UnixNano(),
+			Side:      bitflyerSide(element.Side),
+			Price:     element.Price,
+			Size:      element.Size,
+			TradeID:   &tradeID,
+		})
+		if serr != nil {
+			return nil, fmt.Errorf("normalizeBitflyerExecutions: %v", serr)
+		}
+		ret[i] = Result{Channel: channel, Message: marshaled}
+	}
+	return ret, nil
+}
+
+// convert normalizes results of the json formatter, timestamp is used for records without one.
+func (f *normalizedFormatter) convert(results []Result, timestamp int64) ([]Result, error) {
+	ret := make([]Result, 0, len(results))
+	for _, r := range results {
+		cg, serr := f.channelGroup(r.Channel)
+		if serr != nil {
+			// Results of channels other than trade and orderbook are not normalized
+			continue
+		}
+		if jsondef.IsTypeDef(r.Message) {
+			if cg == streamcommons.ChannelGroupTrade {
+				ret = append(ret, Result{Channel: r.Channel, Message: jsondef.TypeDefTrade})
+			} else {
+				ret = append(ret, Result{Channel: r.Channel, Message: jsondef.TypeDefBookLevelUpdate})
+			}
+			continue
+		}
+		var normalized interface{}
+		if cg == streamcommons.ChannelGroupTrade {
+			normalized, serr = f.normalizeTrade(r.Message, timestamp)
+		} else {
+			normalized, serr = f.normalizeBook(r.Channel, r.Message, timestamp)
+		}
+		if serr != nil {
+			return nil, fmt.Errorf("channel '%s': %v", r.Channel, serr)
+		}
+		marshaled, serr := json.Marshal(normalized)
+		if serr != nil {
+			return nil, fmt.Errorf("channel '%s': %v", r.Channel, serr)
+		}
+		ret = append(ret, Result{Channel: r.Channel, Message: marshaled})
+	}
+	return ret, nil
+}
+
+// FormatStart returns typedefs of the normalized format.
+func (f *normalizedFormatter) FormatStart(urlStr string) ([]Result, error) {
+	results, serr := f.json.FormatStart(urlStr)
+	if serr != nil {
+		return nil, serr
+	}
+	ret, serr := f.convert(results, 0)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatStart: %v", serr)
+	}
+	return ret, nil
+}
+
+// FormatMessage is FormatMessageAt without the time the line was received,
+// timestamp of records is 0 if the exchange does not provide it.
+func (f *normalizedFormatter) FormatMessage(channel string, line []byte) ([]Result, error) {
+	return f.FormatMessageAt(channel, 0, line)
+}
+
+// FormatMessageAt normalizes a message, timestamp is the time the line was received in unix nanosecond
+// and is used for records if the exchange does not provide it.
+func (f *normalizedFormatter) FormatMessageAt(channel string, timestamp int64, line []byte) ([]Result, error) {
+	if f.exchange == streamcommons.ExchangeBitflyer && strings.HasPrefix(channel, streamcommons.BitflyerChannelPrefixLightningExecutions) {
+		ret, serr := f.normalizeBitflyerExecutions(channel, line)
+		if serr != nil {
+			return nil, fmt.Errorf("FormatMessageAt: %v", serr)
+		}
+		return ret, nil
+	}
+	results, serr := f.json.FormatMessage(channel, line)
+	if serr != nil {
+		return nil, serr
+	}
+	ret, serr := f.convert(results, timestamp)
+	if serr != nil {
+		return nil, fmt.Errorf("FormatMessageAt: %v", serr)
+	}
+	return ret, nil
+}
+
+func (f *normalizedFormatter) IsSupported(channel string) bool {
+	_, serr := f.channelGroup(channel)
+	return serr == nil && f.json.IsSupported(channel)
+}
+
+func newNormalizedFormatter(exchange string, json Formatter) *normalizedFormatter {
+	f := new(normalizedFormatter)
+	f.exchange = exchange
+	f.json = json
+	return f
+}
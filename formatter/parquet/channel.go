@@ -0,0 +1,85 @@
+package parquet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/exchangedataset/streamcommons/formatter"
+	"github.com/exchangedataset/streamcommons/formatter/jsondef"
+)
+
+// ChannelWriter writes results of a json formatter into a parquet file per channel.
+// Files are opened on the first typedef of each channel, and records are written into row groups of the channel.
+type ChannelWriter struct {
+	// RowGroupRows is the number of rows in a row group, applied to writers created afterward
+	RowGroupRows int
+	// Codec is the compression codec, applied to writers created afterward
+	Codec Codec
+
+	open     func(channel string) (io.Writer, error)
+	writers  map[string]*Writer
+	typeDefs map[string][]byte
+}
+
+// NewChannelWriter creates a new ChannelWriter, open is called to get the destination of the file for a channel.
+// Writers returned by open are not closed by ChannelWriter.
+func NewChannelWriter(open func(channel string) (io.Writer, error)) *ChannelWriter {
+	w := new(ChannelWriter)
+	w.RowGroupRows = DefaultRowGroupRows
+	w.Codec = CodecUncompressed
+	w.open = open
+	w.writers = make(map[string]*Writer)
+	w.typeDefs = make(map[string][]byte)
+	return w
+}
+
+// Write writes results returned from formatter.
+// Typedefs for channels already opened are ignored if they are the same.
+func (w *ChannelWriter) Write(results []formatter.Result) error {
+	for _, r := range results {
+		if jsondef.IsTypeDef(r.Message) {
+			typeDef, ok := w.typeDefs[r.Channel]
+			if ok {
+				if !bytes.Equal(typeDef, r.Message) {
+					return fmt.Errorf("Write: typedef for channel '%s' has changed", r.Channel)
+				}
+				continue
+			}
+			dst, serr := w.open(r.Channel)
+			if serr != nil {
+				return fmt.Errorf("Write: open: %v", serr)
+			}
+			pw, serr := NewWriter(dst, r.Message)
+			if serr != nil {
+				return fmt.Errorf("Write: channel '%s': %v", r.Channel, serr)
+			}
+			pw.RowGroupRows = w.RowGroupRows
+			pw.Codec = w.Codec
+			w.writers[r.Channel] = pw
+			w.typeDefs[r.Channel] = r.Message
+			continue
+		}
+		pw, ok := w.writers[r.Channel]
+		if !ok {
+			return fmt.Errorf("Write: no typedef for channel '%s'", r.Channel)
+		}
+		serr := pw.WriteRecord(r.Message)
+		if serr != nil {
+			return fmt.Errorf("Write: channel '%s': %v", r.Channel, serr)
+		}
+	}
+	return nil
+}
+
+// Close closes writers for all channels, all writers are closed even if some of them failed.
+// Returns the first error.
+func (w *ChannelWriter) Close() (err error) {
+	for channel, pw := range w.writers {
+		serr := pw.Close()
+		if serr != nil && err == nil {
+			err = fmt.Errorf("Close: channel '%s': %v", channel, serr)
+		}
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/exchangedataset/streamcommons/formatter/jsondef"
+)
+
+// Tags of parquet-go for each type in typedefs
+var columnTags = map[string]string{
+	"price":     "type=DOUBLE",
+	"size":      "type=DOUBLE",
+	"float":     "type=DOUBLE",
+	"int":       "type=INT64",
+	"duration":  "type=INT64",
+	"timestamp": "type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS",
+	"boolean":   "type=BOOLEAN",
+	"symbol":    "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY",
+	"side":      "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY",
+	"string":    "type=BYTE_ARRAY, convertedtype=UTF8",
+	"guid":      "type=BYTE_ARRAY, convertedtype=UTF8",
+}
+
+// column is a column of the schema made from a typedef.
+type column struct {
+	name string
+	// Type in the typedef such as "price" or "timestamp"
+	typeDefType string
+	optional    bool
+	// Type of values, which is the type of the field in the record struct if not optional
+	valueType reflect.Type
+	tag       string
+}
+
+func newColumn(name string, typeDefType string, optional bool) (*column, error) {
+	c := new(column)
+	c.name = name
+	c.typeDefType = typeDefType
+	c.optional = optional
+	tag, ok := columnTags[typeDefType]
+	if !ok {
+		return nil, fmt.Errorf("newColumn: type '%s' of column '%s' is not supported", typeDefType, name)
+	}
+	c.tag = "name=" + name + ", " + tag
+	if optional {
+		c.tag += ", repetitiontype=OPTIONAL"
+	} else {
+		c.tag += ", repetitiontype=REQUIRED"
+	}
+	switch typeDefType {
+	case "price", "size", "float":
+		c.valueType = reflect.TypeOf(float64(0))
+	case "int", "duration", "timestamp":
+		c.valueType = reflect.TypeOf(int64(0))
+	case "boolean":
+		c.valueType = reflect.TypeOf(false)
+	default:
+		c.valueType = reflect.TypeOf("")
+	}
+	return c, nil
+}
+
+// schemaColumns makes columns from the typedef and the struct of records it describes.
+// Columns are in the same order as fields of the struct, and pointer fields are optional.
+func schemaColumns(typeDef []byte) ([]*column, error) {
+	rt := jsondef.RecordType(typeDef)
+	if rt == nil {
+		return nil, errors.New("schemaColumns: not a typedef")
+	}
+	types := make(map[string]string)
+	serr := json.Unmarshal(typeDef, &types)
+	if serr != nil {
+		return nil, fmt.Errorf("schemaColumns: %v", serr)
+	}
+	columns := make([]*column, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		typ, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("schemaColumns: field '%s' is not in the typedef", name)
+		}
+		c, serr := newColumn(name, typ, field.Type.Kind() == reflect.Ptr)
+		if serr != nil {
+			return nil, fmt.Errorf("schemaColumns: %v", serr)
+		}
+		columns[i] = c
+	}
+	return columns, nil
+}
+
+// rowType makes the struct type of rows for parquet-go, the nth field is for the nth column.
+func rowType(columns []*column) reflect.Type {
+	fields := make([]reflect.StructField, len(columns))
+	for i, c := range columns {
+		typ := c.valueType
+		if c.optional {
+			typ = reflect.PtrTo(typ)
+		}
+		fields[i] = reflect.StructField{
+			Name: "Column" + strconv.Itoa(i),
+			Type: typ,
+			Tag:  reflect.StructTag(`parquet:"` + c.tag + `"`),
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// parse parses a json value of the column, raw is nil if the field is absent.
+// Returns invalid value for null.
+// Timestamps and durations are strings of nanoseconds in formatted records.
+func (c *column) parse(raw json.RawMessage) (v reflect.Value, err error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		if !c.optional {
+			err = fmt.Errorf("parse: column '%s' is required but null", c.name)
+		}
+		return
+	}
+	var str string
+	if raw[0] == '"' {
+		serr := json.Unmarshal(raw, &str)
+		if serr != nil {
+			err = fmt.Errorf("parse: column '%s': %v", c.name, serr)
+			return
+		}
+	} else if c.valueType.Kind() == reflect.String {
+		err = fmt.Errorf("parse: column '%s' is not a string", c.name)
+		return
+	} else {
+		str = string(raw)
+	}
+	var parsed interface{}
+	var serr error
+	switch c.valueType.Kind() {
+	case reflect.Float64:
+		parsed, serr = strconv.ParseFloat(str, 64)
+	case reflect.Int64:
+		parsed, serr = strconv.ParseInt(str, 10, 64)
+	case reflect.Bool:
+		parsed, serr = strconv.ParseBool(str)
+	default:
+		parsed = str
+	}
+	if serr != nil {
+		err = fmt.Errorf("parse: column '%s': %v", c.name, serr)
+		return
+	}
+	return reflect.ValueOf(parsed), nil
+}
@@ -0,0 +1,135 @@
+package parquet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Codec is a compression codec for pages.
+type Codec int32
+
+// Supported codecs
+const (
+	CodecUncompressed = Codec(pq.CompressionCodec_UNCOMPRESSED)
+	CodecSnappy       = Codec(pq.CompressionCodec_SNAPPY)
+	CodecGzip         = Codec(pq.CompressionCodec_GZIP)
+)
+
+// DefaultRowGroupRows is the default number of rows in a row group.
+const DefaultRowGroupRows = 100000
+
+// Writer writes formatted records of a typedef into a parquet file.
+// Column types are decided by the typedef and nullability by the struct in jsondef:
+// "timestamp" is int64 nanosecond timestamp, "symbol" and "side" are dictionary-encoded strings,
+// "price", "size" and "float" are float64 and pointer fields are optional.
+type Writer struct {
+	// RowGroupRows is the number of rows buffered before a row group is written
+	RowGroupRows int
+	// Codec is the compression codec used for pages
+	Codec Codec
+
+	pw      *writer.ParquetWriter
+	columns []*column
+	rowType reflect.Type
+	rows    int
+	closed  bool
+}
+
+// NewWriter creates a new Writer for records of the typedef and writes the file header.
+// It does not close the underlying writer.
+func NewWriter(w io.Writer, typeDef []byte) (*Writer, error) {
+	columns, serr := schemaColumns(typeDef)
+	if serr != nil {
+		return nil, fmt.Errorf("NewWriter: %v", serr)
+	}
+	rt := rowType(columns)
+	pw, serr := writer.NewParquetWriterFromWriter(w, reflect.New(rt).Interface(), 1)
+	if serr != nil {
+		return nil, fmt.Errorf("NewWriter: %v", serr)
+	}
+	fw := new(Writer)
+	fw.RowGroupRows = DefaultRowGroupRows
+	fw.Codec = CodecUncompressed
+	fw.pw = pw
+	fw.columns = columns
+	fw.rowType = rt
+	return fw, nil
+}
+
+// WriteRecord writes a formatted record, which is a json object following the typedef.
+// A row group is written when the number of buffered rows reaches RowGroupRows.
+func (w *Writer) WriteRecord(record []byte) error {
+	if w.closed {
+		return errors.New("WriteRecord: writer is closed")
+	}
+	fields := make(map[string]json.RawMessage)
+	serr := json.Unmarshal(record, &fields)
+	if serr != nil {
+		return fmt.Errorf("WriteRecord: %v", serr)
+	}
+	row := reflect.New(w.rowType).Elem()
+	for i, c := range w.columns {
+		v, serr := c.parse(fields[c.name])
+		if serr != nil {
+			return fmt.Errorf("WriteRecord: %v", serr)
+		}
+		if !v.IsValid() {
+			// Null is nil pointer
+			continue
+		}
+		if c.optional {
+			ptr := reflect.New(c.valueType)
+			ptr.Elem().Set(v)
+			v = ptr
+		}
+		row.Field(i).Set(v)
+	}
+	w.pw.CompressionType = pq.CompressionCodec(w.Codec)
+	serr = w.pw.Write(row.Interface())
+	if serr != nil {
+		return fmt.Errorf("WriteRecord: %v", serr)
+	}
+	w.rows++
+	if w.rows >= w.RowGroupRows {
+		serr = w.Flush()
+		if serr != nil {
+			return fmt.Errorf("WriteRecord: %v", serr)
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered rows as a row group.
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	w.pw.CompressionType = pq.CompressionCodec(w.Codec)
+	serr := w.pw.Flush(true)
+	if serr != nil {
+		return fmt.Errorf("Flush: %v", serr)
+	}
+	w.rows = 0
+	return nil
+}
+
+// Close writes buffered rows and the file footer.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.pw.CompressionType = pq.CompressionCodec(w.Codec)
+	serr := w.pw.WriteStop()
+	if serr != nil {
+		return fmt.Errorf("Close: %v", serr)
+	}
+	return nil
+}
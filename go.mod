@@ -6,4 +6,5 @@ require (
 	github.com/aws/aws-lambda-go v1.19.1
 	github.com/aws/aws-sdk-go-v2 v0.24.0
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/xitongsys/parquet-go v1.6.2
 )
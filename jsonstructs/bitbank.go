@@ -19,7 +19,12 @@ type BitbankTransactions struct {
 	Side          string `json:"side"`
 	Amount        string `json:"amount"`
 	Price         string `json:"price"`
-	ExecutedAt    int64  `json:"excuted_at"`
+	ExecutedAt    int64  `json:"executed_at"`
+}
+
+// BitbankTransactionsData is data of transactions message, it holds multiple transactions.
+type BitbankTransactionsData struct {
+	Transactions []BitbankTransactions `json:"transactions"`
 }
 
 // BitbankDepthDiff is depth diff message from Bitbank.
@@ -42,9 +47,17 @@ type BitbankRoot struct {
 	Message  json.RawMessage `json:"message"`
 }
 
+// BitbankMessage is message object in BitbankRoot, actual payload is wrapped in data.
+type BitbankMessage struct {
+	Data json.RawMessage `json:"data"`
+}
+
 // BitbankWrapper is wrapper for message (possibly socket.io spec).
 type BitbankWrapper [2]json.RawMessage
 
+// BitbankEventMessage is the event name of socket.io message that carries BitbankRoot.
+const BitbankEventMessage = "message"
+
 // BitbankSubscribe is subscribe message.
 type BitbankSubscribe [2]string
 
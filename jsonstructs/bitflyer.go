@@ -78,6 +78,8 @@ type BitflyerSubscribed struct {
 	JSONRPC string `json:"jsonrpc"`
 	ID      int    `json:"id"`
 	Result  bool   `json:"result"`
+	// Error is set instead of Result if the request failed
+	Error json.RawMessage `json:"error,omitempty"`
 }
 
 // Initialize initialize constants on this struct
@@ -87,3 +89,8 @@ func (b *BitflyerSubscribed) Initialize() {
 
 // BitflyerStateSubscribed is a list of subscribed channels listed in state line in dataset
 type BitflyerStateSubscribed []string
+
+// BitflyerStateSubscribing is a map of request IDs and channels of subscribe requests
+// that have not been responded yet, listed in state line in dataset
+// map[messageID]channel
+type BitflyerStateSubscribing map[int]string
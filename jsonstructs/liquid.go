@@ -77,6 +77,10 @@ type LiquidConnectionEstablishedData struct {
 	SocketID        string `json:"socket_id"`
 }
 
+// LiquidPriceLadder is data of price ladder message from Liquid, it is encoded in string in LiquidMessageRoot.
+// Each element is a pair of price and quantity.
+type LiquidPriceLadder [][]string
+
 // LiquidSubscribeData is data of subscribe event.
 type LiquidSubscribeData struct {
 	Channel string `json:"channel"`
@@ -0,0 +1,96 @@
+package streamcommons
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDumpKeyExtension is the extension of dump objects used if KeyScheme does not specify one.
+const defaultDumpKeyExtension = ".gz"
+
+const listDumpKeysPageSize = 1000
+
+// KeyScheme is the naming scheme of dump objects in a storage.
+// Keys are "<Prefix><exchange>/<minute><Extension>", the zero value has no prefix and ".gz" extension.
+type KeyScheme struct {
+	// Prefix is prepended to all keys, e.g. "research/"
+	Prefix string
+	// Extension is appended to all keys, ".gz" is used if empty
+	Extension string
+}
+
+func (k KeyScheme) extension() string {
+	if k.Extension == "" {
+		return defaultDumpKeyExtension
+	}
+	return k.Extension
+}
+
+// ExchangePrefix returns the prefix of keys of dump objects of the exchange.
+func (k KeyScheme) ExchangePrefix(exchange string) string {
+	return k.Prefix + exchange + "/"
+}
+
+// DumpKey returns the key of the dump object of the exchange for the minute including the timestamp.
+// Timestamps in keys are unix nanosecond truncated to minute, so that keys are in chronological order.
+func (k KeyScheme) DumpKey(exchange string, timestamp int64) string {
+	minute := timestamp - timestamp%int64(time.Minute)
+	return k.ExchangePrefix(exchange) + strconv.FormatInt(minute, 10) + k.extension()
+}
+
+// ListDumpKeys lists keys of dump objects of the exchange which has lines from start (inclusive) to end (exclusive).
+// Other objects under the prefix of the exchange such as indices are ignored.
+func (k KeyScheme) ListDumpKeys(ctx context.Context, s Storage, exchange string, start int64, end int64) ([]string, error) {
+	if end <= start {
+		return nil, fmt.Errorf("ListDumpKeys: end is not after start")
+	}
+	prefix := k.ExchangePrefix(exchange)
+	startMinute := start - start%int64(time.Minute)
+	lastKey := k.DumpKey(exchange, end-1)
+	opts := ListOptions{
+		// Any key before the start minute is less than this
+		StartAfter: prefix + strconv.FormatInt(startMinute-1, 10),
+		MaxKeys:    listDumpKeysPageSize,
+	}
+	keys := make([]string, 0)
+	for {
+		objects, serr := s.List(ctx, prefix, opts)
+		if serr != nil {
+			return nil, fmt.Errorf("ListDumpKeys: %v", serr)
+		}
+		for _, obj := range objects {
+			if obj.Key > lastKey {
+				return keys, nil
+			}
+			if strings.HasSuffix(obj.Key, k.extension()) {
+				keys = append(keys, obj.Key)
+			}
+		}
+		if len(objects) < opts.MaxKeys {
+			return keys, nil
+		}
+		opts.StartAfter = objects[len(objects)-1].Key
+	}
+}
+
+// DefaultKeyScheme returns the key scheme of the default storage.
+func DefaultKeyScheme() (KeyScheme, error) {
+	config, serr := StorageConfigFromEnv()
+	if serr != nil {
+		return KeyScheme{}, fmt.Errorf("DefaultKeyScheme: %v", serr)
+	}
+	return config.Keys, nil
+}
+
+// DumpKey returns the key of the dump object in the zero value KeyScheme.
+func DumpKey(exchange string, timestamp int64) string {
+	return KeyScheme{}.DumpKey(exchange, timestamp)
+}
+
+// ListDumpKeys lists keys of dump objects in the zero value KeyScheme.
+func ListDumpKeys(ctx context.Context, s Storage, exchange string, start int64, end int64) ([]string, error) {
+	return KeyScheme{}.ListDumpKeys(ctx, s, exchange, start, end)
+}
@@ -0,0 +1,249 @@
+package streamcommons
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// KeyStore stores API-keys with their quota, enablement and used count.
+type KeyStore interface {
+	// Available returns true if the API-key exists, is enabled and has not reached the quota.
+	Available(ctx context.Context, key []byte) (bool, error)
+	// IncrementUsed adds cost to the used count of the API-key, returns error if the API-key does not exist.
+	IncrementUsed(ctx context.Context, key []byte, cost int) error
+	// Reserve atomically adds cost to the used count if the API-key is enabled and the used count
+	// stays under the quota, returns false if it is not available.
+	Reserve(ctx context.Context, key []byte, cost int) (bool, error)
+	// Refund subtracts cost charged by Reserve from the used count.
+	Refund(ctx context.Context, key []byte, cost int) error
+	// Policy returns the policy of the API-key, zero value if it has no restriction.
+	Policy(ctx context.Context, key []byte) (*KeyPolicy, error)
+}
+
+// checkIncrementRows returns error if rows affected by incrementing the used count is not 2.
+// SET counts as rowsAffected + actual update, this depends on the procedure in mysql.
+func checkIncrementRows(rows int64) error {
+	if rows != 2 {
+		return errors.New("Too many or less rows affected: API key might not exist")
+	}
+	return nil
+}
+
+// MySQLKeyStore is KeyStore using stored procedures of the main database.
+type MySQLKeyStore struct {
+	db *sql.DB
+}
+
+// NewMySQLKeyStore creates new MySQLKeyStore on the database connected by ConnectDatabase.
+func NewMySQLKeyStore(db *sql.DB) *MySQLKeyStore {
+	s := new(MySQLKeyStore)
+	s.db = db
+	return s
+}
+
+// Available calls apikey_available procedure.
+func (s *MySQLKeyStore) Available(ctx context.Context, key []byte) (bool, error) {
+	var apikeyAvailable int
+	row := s.db.QueryRowContext(ctx, "SELECT exchangedataset.apikey_available(?)", key)
+	serr := row.Scan(&apikeyAvailable)
+	if serr != nil {
+		return false, fmt.Errorf("apikey_available procedure call failed: %v", serr)
+	}
+	return apikeyAvailable == 1, nil
+}
+
+// IncrementUsed calls increment_apikey_used_now procedure.
+func (s *MySQLKeyStore) IncrementUsed(ctx context.Context, key []byte, cost int) error {
+	return incrementUsed(ctx, s.db, key, cost)
+}
+
+// mysqlKeyStoreLockTimeout is the number of seconds Reserve waits for the lock of the API-key.
+const mysqlKeyStoreLockTimeout = 5
+
+// sqlExecer is *sql.DB or *sql.Conn.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// incrementUsed calls increment_apikey_used_now procedure on the database or the connection.
+func incrementUsed(ctx context.Context, conn sqlExecer, key []byte, cost int) error {
+	res, serr := conn.ExecContext(ctx, "CALL exchangedataset.increment_apikey_used_now(?, ?)", key, cost)
+	if serr != nil {
+		return fmt.Errorf("Call failed: %v", serr)
+	}
+	rows, serr := res.RowsAffected()
+	if serr != nil {
+		return fmt.Errorf("RowsAffected returned error: %v", serr)
+	}
+	return checkIncrementRows(rows)
+}
+
+// Reserve increments the used count and checks availability while holding a named lock of the API-key,
+// so that concurrent reservations for the same API-key are serialized.
+// The increment is refunded if the API-key is not available after it, apikey_available is false when
+// the used count reaches the quota, so a reservation which uses up the quota exactly is also rejected.
+func (s *MySQLKeyStore) Reserve(ctx context.Context, key []byte, cost int) (available bool, err error) {
+	// Named locks belong to the connection
+	conn, serr := s.db.Conn(ctx)
+	if serr != nil {
+		return false, fmt.Errorf("Conn failed: %v", serr)
+	}
+	defer conn.Close()
+	lockName := "exchangedataset.apikey." + hex.EncodeToString(key)
+	var locked sql.NullInt64
+	serr = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, mysqlKeyStoreLockTimeout).Scan(&locked)
+	if serr != nil {
+		return false, fmt.Errorf("GET_LOCK failed: %v", serr)
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return false, errors.New("GET_LOCK timed out")
+	}
+	defer func() {
+		// Release the lock even if ctx is canceled
+		_, serr := conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", lockName)
+		if serr != nil && err == nil {
+			err = fmt.Errorf("RELEASE_LOCK failed: %v", serr)
+		}
+	}()
+	var apikeyAvailable int
+	serr = conn.QueryRowContext(ctx, "SELECT exchangedataset.apikey_available(?)", key).Scan(&apikeyAvailable)
+	if serr != nil {
+		return false, fmt.Errorf("apikey_available procedure call failed: %v", serr)
+	}
+	if apikeyAvailable != 1 {
+		return false, nil
+	}
+	serr = incrementUsed(ctx, conn, key, cost)
+	if serr != nil {
+		return false, serr
+	}
+	serr = conn.QueryRowContext(ctx, "SELECT exchangedataset.apikey_available(?)", key).Scan(&apikeyAvailable)
+	if serr == nil && apikeyAvailable == 1 {
+		return true, nil
+	}
+	// Do not leave the increment even if ctx is canceled
+	rerr := incrementUsed(context.Background(), conn, key, -cost)
+	if rerr != nil {
+		return false, fmt.Errorf("refund failed: %v", rerr)
+	}
+	if serr != nil {
+		return false, fmt.Errorf("apikey_available procedure call failed: %v", serr)
+	}
+	return false, nil
+}
+
+// Refund calls increment_apikey_used_now procedure with negative cost.
+// The procedure adds the cost to the used count as it is without checking its sign, Reserve relies on it
+// for rollback too. If it ever rejects negative costs, the error is returned and nothing is refunded.
+// The procedure counts the used quota of the current period, so a refund made after the period changed
+// is subtracted from the new period. Reservations only last while a request is processed, so it happens only
+// for requests across the boundary, and the API-key is undercharged by at most the reserved cost.
+func (s *MySQLKeyStore) Refund(ctx context.Context, key []byte, cost int) error {
+	if cost < 0 {
+		return errors.New("Refund: negative cost")
+	}
+	serr := incrementUsed(ctx, s.db, key, -cost)
+	if serr != nil {
+		return fmt.Errorf("Refund: %v", serr)
+	}
+	return nil
+}
+
+// MemoryKeyStore is KeyStore on memory with the same semantics as MySQLKeyStore, useful for testing.
+type MemoryKeyStore struct {
+	keys     map[string]*memoryKey
+	policies map[string]*KeyPolicy
+	lock     sync.Mutex
+}
+
+type memoryKey struct {
+	quota   int64
+	used    int64
+	enabled bool
+}
+
+// NewMemoryKeyStore creates new empty MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	s := new(MemoryKeyStore)
+	s.keys = make(map[string]*memoryKey)
+	s.policies = make(map[string]*KeyPolicy)
+	return s
+}
+
+// SetKey adds or updates the API-key with the quota in bytes, the used count is kept if it already exists.
+func (s *MemoryKeyStore) SetKey(key []byte, quota int64, enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[string(key)]
+	if !ok {
+		k = new(memoryKey)
+		s.keys[string(key)] = k
+	}
+	k.quota = quota
+	k.enabled = enabled
+}
+
+// Used returns the used count of the API-key, ok is false if it does not exist.
+func (s *MemoryKeyStore) Used(key []byte) (used int64, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[string(key)]
+	if !ok {
+		return 0, false
+	}
+	return k.used, true
+}
+
+// Available returns true if the API-key exists, is enabled and the used count is less than the quota.
+func (s *MemoryKeyStore) Available(ctx context.Context, key []byte) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[string(key)]
+	return ok && k.enabled && k.used < k.quota, nil
+}
+
+// IncrementUsed adds cost to the used count of the API-key regardless of the quota and enablement,
+// as the procedure does.
+func (s *MemoryKeyStore) IncrementUsed(ctx context.Context, key []byte, cost int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Emulate the procedure, which affects one row for SET and one for the update
+	rows := int64(1)
+	if k, ok := s.keys[string(key)]; ok {
+		k.used += int64(cost)
+		rows++
+	}
+	return checkIncrementRows(rows)
+}
+
+// Reserve adds cost to the used count if the API-key is enabled and the used count stays under the quota,
+// reservations which use up the quota exactly are rejected as MySQLKeyStore does.
+func (s *MemoryKeyStore) Reserve(ctx context.Context, key []byte, cost int) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[string(key)]
+	if !ok || !k.enabled || k.used+int64(cost) >= k.quota {
+		return false, nil
+	}
+	k.used += int64(cost)
+	return true, nil
+}
+
+// Refund subtracts cost from the used count of the API-key.
+func (s *MemoryKeyStore) Refund(ctx context.Context, key []byte, cost int) error {
+	if cost < 0 {
+		return errors.New("Refund: negative cost")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[string(key)]
+	if !ok {
+		return errors.New("Refund: API key does not exist")
+	}
+	k.used -= int64(cost)
+	return nil
+}
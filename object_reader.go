@@ -0,0 +1,116 @@
+package streamcommons
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// DefaultObjectChunkSize is the default size of chunks ObjectReaderAt fetches at once.
+const DefaultObjectChunkSize = 1 << 20
+
+// objectReaderAtCachedChunks is the number of recently read chunks ObjectReaderAt keeps.
+const objectReaderAtCachedChunks = 4
+
+// ObjectReaderAt is io.ReaderAt over an object in a storage which fetches chunks lazily with range reads.
+// Use io.NewSectionReader(r, 0, r.Size()) for a seekable reader.
+type ObjectReaderAt struct {
+	ctx       context.Context
+	storage   Storage
+	key       string
+	size      int64
+	chunkSize int64
+	lock      sync.Mutex
+	// Recently read chunks, the most recent one comes last
+	chunks []objectChunk
+}
+
+type objectChunk struct {
+	index int64
+	data  []byte
+}
+
+// NewObjectReaderAt creates new ObjectReaderAt for the object, returns error if the object does not exist.
+// chunkSize is the number of bytes fetched at once, DefaultObjectChunkSize is used if it is not positive.
+func NewObjectReaderAt(ctx context.Context, s Storage, key string, chunkSize int64) (*ObjectReaderAt, error) {
+	info, serr := s.Stat(ctx, key)
+	if serr != nil {
+		return nil, fmt.Errorf("NewObjectReaderAt: %v", serr)
+	}
+	if info == nil {
+		// Not wrapped so that callers can check it with errors.Is
+		return nil, &GetError{Key: key, Err: ErrObjectNotFound}
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultObjectChunkSize
+	}
+	r := new(ObjectReaderAt)
+	r.ctx = ctx
+	r.storage = s
+	r.key = key
+	r.size = info.Size
+	r.chunkSize = chunkSize
+	return r, nil
+}
+
+// Size returns the size of the object.
+func (r *ObjectReaderAt) Size() int64 {
+	return r.size
+}
+
+// chunk returns the chunk of the index, fetching it if not cached.
+func (r *ObjectReaderAt) chunk(index int64) ([]byte, error) {
+	for i, c := range r.chunks {
+		if c.index == index {
+			// Move to the last as the most recent
+			r.chunks = append(append(r.chunks[:i], r.chunks[i+1:]...), c)
+			return c.data, nil
+		}
+	}
+	body, serr := r.storage.GetRange(r.ctx, r.key, index*r.chunkSize, r.chunkSize)
+	if serr != nil {
+		return nil, serr
+	}
+	if body == nil {
+		return nil, &GetError{Key: r.key, Err: ErrObjectNotFound}
+	}
+	defer body.Close()
+	data, serr := ioutil.ReadAll(body)
+	if serr != nil {
+		return nil, serr
+	}
+	if len(r.chunks) >= objectReaderAtCachedChunks {
+		r.chunks = r.chunks[1:]
+	}
+	r.chunks = append(r.chunks, objectChunk{index: index, data: data})
+	return data, nil
+}
+
+// ReadAt reads len(p) bytes from the offset of the object, fetching only the chunks needed.
+func (r *ObjectReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("ReadAt: negative offset")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		index := pos / r.chunkSize
+		data, serr := r.chunk(index)
+		if serr != nil {
+			return n, fmt.Errorf("ReadAt: %v", serr)
+		}
+		start := pos - index*r.chunkSize
+		if start >= int64(len(data)) {
+			// Object became shorter than it was
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], data[start:])
+	}
+	return n, nil
+}
@@ -0,0 +1,151 @@
+package streamcommons
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KeyPolicy restricts data which can be fetched using an API-key.
+// Zero value allows everything.
+type KeyPolicy struct {
+	// Exchanges is the list of allowed exchanges, all exchanges are allowed if empty
+	Exchanges []string `json:"exchanges,omitempty"`
+	// ChannelGroups is the list of allowed channel groups, all channel groups are allowed if empty
+	ChannelGroups []ChannelGroup `json:"channelGroups,omitempty"`
+	// MaxHistorySeconds is how far data can be fetched back from now in seconds, no limit if zero
+	MaxHistorySeconds int64 `json:"maxHistorySeconds,omitempty"`
+	// AllowedStart and AllowedEnd is the range of time in unix nanosecond that data can be fetched,
+	// no limit if zero
+	AllowedStart int64 `json:"allowedStart,omitempty"`
+	AllowedEnd   int64 `json:"allowedEnd,omitempty"`
+}
+
+// Check returns error with detailed message if the request is not allowed by the policy.
+// start is inclusive and end is exclusive in unix nanosecond.
+func (p *KeyPolicy) Check(exchange string, channels []string, start int64, end int64, now time.Time) error {
+	if end <= start {
+		return errors.New("end must be after start")
+	}
+	if len(p.Exchanges) > 0 && !containsString(p.Exchanges, exchange) {
+		return fmt.Errorf("exchange '%s' is not allowed for this API key", exchange)
+	}
+	if len(p.ChannelGroups) > 0 {
+		for _, ch := range channels {
+			cg, serr := GetChannelGroup(exchange, ch)
+			if serr != nil {
+				return serr
+			}
+			if !containsChannelGroup(p.ChannelGroups, cg) {
+				return fmt.Errorf("channel '%s' is in channel group '%s' which is not allowed for this API key", ch, cg)
+			}
+		}
+	}
+	if p.AllowedStart != 0 && start < p.AllowedStart {
+		return fmt.Errorf("start must be at or after %d for this API key", p.AllowedStart)
+	}
+	if p.AllowedEnd != 0 && end > p.AllowedEnd {
+		return fmt.Errorf("end must be at or before %d for this API key", p.AllowedEnd)
+	}
+	if p.MaxHistorySeconds != 0 {
+		maxHistory := time.Duration(p.MaxHistorySeconds) * time.Second
+		oldest := now.Add(-maxHistory).UnixNano()
+		if start < oldest {
+			return fmt.Errorf("start must be at or after %d for this API key, history is limited to %s", oldest, maxHistory)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func containsChannelGroup(list []ChannelGroup, cg ChannelGroup) bool {
+	for _, c := range list {
+		if c == cg {
+			return true
+		}
+	}
+	return false
+}
+
+// MySQLKeyPolicySchema is the definition of the table used by MySQLKeyStore.Policy.
+// API keys without a row have no restriction.
+const MySQLKeyPolicySchema = `CREATE TABLE IF NOT EXISTS exchangedataset.apikey_policy (
+	apikey VARBINARY(255) NOT NULL PRIMARY KEY,
+	policy JSON NOT NULL
+)`
+
+// Policy reads the policy of the API-key from the table of MySQLKeyPolicySchema.
+func (s *MySQLKeyStore) Policy(ctx context.Context, key []byte) (*KeyPolicy, error) {
+	var marshaled []byte
+	serr := s.db.QueryRowContext(ctx, "SELECT policy FROM exchangedataset.apikey_policy WHERE apikey = ?", key).Scan(&marshaled)
+	if serr == sql.ErrNoRows {
+		return new(KeyPolicy), nil
+	}
+	if serr != nil {
+		return nil, fmt.Errorf("Policy: %v", serr)
+	}
+	policy := new(KeyPolicy)
+	serr = json.Unmarshal(marshaled, policy)
+	if serr != nil {
+		return nil, fmt.Errorf("Policy: %v", serr)
+	}
+	return policy, nil
+}
+
+// SetPolicy sets the policy of the API-key, nil removes restrictions.
+func (s *MemoryKeyStore) SetPolicy(key []byte, policy *KeyPolicy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if policy == nil {
+		delete(s.policies, string(key))
+		return
+	}
+	s.policies[string(key)] = policy
+}
+
+// Policy returns the policy of the API-key set by SetPolicy.
+func (s *MemoryKeyStore) Policy(ctx context.Context, key []byte) (*KeyPolicy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	policy, ok := s.policies[string(key)]
+	if !ok {
+		return new(KeyPolicy), nil
+	}
+	return policy, nil
+}
+
+// LoadPolicy loads the policy of the API key from the key store, the demo API key always has DemoKeyPolicy.
+func (a *APIKey) LoadPolicy(ctx context.Context, store KeyStore) error {
+	if a.Demo {
+		policy := DemoKeyPolicy()
+		a.Policy = &policy
+		return nil
+	}
+	policy, serr := store.Policy(ctx, a.Key)
+	if serr != nil {
+		return fmt.Errorf("LoadPolicy: %v", serr)
+	}
+	a.Policy = policy
+	return nil
+}
+
+// Authorize returns error with detailed message if the API key is not allowed to fetch the data.
+// The policy must be loaded by LoadPolicy unless it is the demo API key.
+func (a *APIKey) Authorize(exchange string, channels []string, start int64, end int64) error {
+	if a.Policy == nil {
+		// Fail safe for forgetting to load the policy
+		return errors.New("policy of the API key is not loaded")
+	}
+	return a.Policy.Check(exchange, channels, start, end, time.Now())
+}
@@ -0,0 +1,242 @@
+package streamcommons
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RateLimitConfig is the configuration of the token bucket of each API-key.
+type RateLimitConfig struct {
+	// Rate is the number of requests allowed per second in average
+	Rate float64
+	// Burst is the maximum number of requests allowed at once, it is the size of the bucket
+	Burst int
+}
+
+// Validate returns error if the bucket can not allow any request or never refills.
+func (c RateLimitConfig) Validate() error {
+	if !(c.Rate > 0) || math.IsInf(c.Rate, 1) {
+		return fmt.Errorf("rate must be positive and finite, got %v", c.Rate)
+	}
+	if c.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1, got %d", c.Burst)
+	}
+	return nil
+}
+
+// DefaultRateLimitConfig allows 10 requests per second with bursts of 20 requests.
+var DefaultRateLimitConfig = RateLimitConfig{Rate: 10, Burst: 20}
+
+// RateLimitResult is the result of taking a token from the bucket of an API-key.
+type RateLimitResult struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests which can be made right now
+	Remaining int
+	// RetryAfter is the time until the next request is allowed, zero if allowed
+	RetryAfter time.Duration
+}
+
+// RateLimiter limits the rate of requests for each API-key.
+type RateLimiter interface {
+	// Allow takes a token from the bucket of the key.
+	Allow(ctx context.Context, key []byte) (*RateLimitResult, error)
+}
+
+// take refills the bucket since last and takes a token from it, returns the new number of tokens.
+func (c RateLimitConfig) take(tokens float64, last time.Time, now time.Time) (float64, *RateLimitResult) {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(float64(c.Burst), tokens+elapsed*c.Rate)
+	}
+	result := &RateLimitResult{Limit: c.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / c.Rate * float64(time.Second))
+	}
+	result.Remaining = int(tokens)
+	return tokens, result
+}
+
+// localRateLimiterMaxKeys is the number of buckets LocalRateLimiter keeps at most.
+const localRateLimiterMaxKeys = 10000
+
+// localRateLimiterSweepInterval is the interval LocalRateLimiter removes full buckets.
+const localRateLimiterSweepInterval = time.Minute
+
+type localBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// LocalRateLimiter is RateLimiter in the process, it only limits requests handled by the same process.
+// Buckets are kept in LRU order, full buckets are removed periodically as they are the same as new buckets,
+// and the least recently used one is evicted if there are too many.
+type LocalRateLimiter struct {
+	config  RateLimitConfig
+	buckets map[string]*list.Element
+	// lru has *localBucket, the front is the most recently used
+	lru       *list.List
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// NewLocalRateLimiter creates new LocalRateLimiter, returns error if the config is invalid.
+func NewLocalRateLimiter(config RateLimitConfig) (*LocalRateLimiter, error) {
+	serr := config.Validate()
+	if serr != nil {
+		return nil, fmt.Errorf("NewLocalRateLimiter: %v", serr)
+	}
+	l := new(LocalRateLimiter)
+	l.config = config
+	l.buckets = make(map[string]*list.Element)
+	l.lru = list.New()
+	l.lastSweep = time.Now()
+	return l, nil
+}
+
+// Allow takes a token from the bucket of the key.
+func (l *LocalRateLimiter) Allow(ctx context.Context, key []byte) (*RateLimitResult, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= localRateLimiterSweepInterval {
+		l.sweep(now)
+		l.lastSweep = now
+	}
+	var b *localBucket
+	if e, ok := l.buckets[string(key)]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*localBucket)
+	} else {
+		if l.lru.Len() >= localRateLimiterMaxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*localBucket).key)
+		}
+		b = &localBucket{key: string(key), tokens: float64(l.config.Burst), last: now}
+		l.buckets[b.key] = l.lru.PushFront(b)
+	}
+	var result *RateLimitResult
+	b.tokens, result = l.config.take(b.tokens, b.last, now)
+	b.last = now
+	return result, nil
+}
+
+// sweep removes buckets which are full from the least recently used.
+// Buckets not used for Burst/Rate seconds are always full, so it stops at the first bucket used after that.
+func (l *LocalRateLimiter) sweep(now time.Time) {
+	fullAfter := time.Duration(float64(l.config.Burst) / l.config.Rate * float64(time.Second))
+	for e := l.lru.Back(); e != nil; {
+		b := e.Value.(*localBucket)
+		if now.Sub(b.last) < fullAfter {
+			return
+		}
+		prev := e.Prev()
+		l.lru.Remove(e)
+		delete(l.buckets, b.key)
+		e = prev
+	}
+}
+
+// MySQLRateLimiterSchema is the definition of the table used by MySQLRateLimiter.
+const MySQLRateLimiterSchema = `CREATE TABLE IF NOT EXISTS exchangedataset.apikey_rate_limit (
+	apikey VARBINARY(255) NOT NULL PRIMARY KEY,
+	tokens DOUBLE NOT NULL,
+	updated_at BIGINT NOT NULL
+)`
+
+// MySQLRateLimiter is RateLimiter shared by processes using the main database.
+// The table of MySQLRateLimiterSchema must exist.
+type MySQLRateLimiter struct {
+	config RateLimitConfig
+	db     *sql.DB
+}
+
+// NewMySQLRateLimiter creates new MySQLRateLimiter on the database connected by ConnectDatabase,
+// returns error if the config is invalid.
+func NewMySQLRateLimiter(db *sql.DB, config RateLimitConfig) (*MySQLRateLimiter, error) {
+	serr := config.Validate()
+	if serr != nil {
+		return nil, fmt.Errorf("NewMySQLRateLimiter: %v", serr)
+	}
+	l := new(MySQLRateLimiter)
+	l.config = config
+	l.db = db
+	return l, nil
+}
+
+// Allow takes a token from the bucket of the key in a transaction locking its row.
+func (l *MySQLRateLimiter) Allow(ctx context.Context, key []byte) (result *RateLimitResult, err error) {
+	now := time.Now()
+	tx, serr := l.db.BeginTx(ctx, nil)
+	if serr != nil {
+		return nil, fmt.Errorf("Allow: %v", serr)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	// Make sure the row exists so that it can be locked
+	_, serr = tx.ExecContext(ctx, "INSERT IGNORE INTO exchangedataset.apikey_rate_limit (apikey, tokens, updated_at) VALUES (?, ?, ?)", key, l.config.Burst, now.UnixNano())
+	if serr != nil {
+		return nil, fmt.Errorf("Allow: %v", serr)
+	}
+	var tokens float64
+	var updatedAt int64
+	serr = tx.QueryRowContext(ctx, "SELECT tokens, updated_at FROM exchangedataset.apikey_rate_limit WHERE apikey = ? FOR UPDATE", key).Scan(&tokens, &updatedAt)
+	if serr != nil {
+		return nil, fmt.Errorf("Allow: %v", serr)
+	}
+	tokens, result = l.config.take(tokens, time.Unix(0, updatedAt), now)
+	_, serr = tx.ExecContext(ctx, "UPDATE exchangedataset.apikey_rate_limit SET tokens = ?, updated_at = ? WHERE apikey = ?", tokens, now.UnixNano(), key)
+	if serr != nil {
+		return nil, fmt.Errorf("Allow: %v", serr)
+	}
+	serr = tx.Commit()
+	if serr != nil {
+		return nil, fmt.Errorf("Allow: %v", serr)
+	}
+	return result, nil
+}
+
+// SetHeaders sets headers for the remaining budget, and Retry-After if the request is not allowed.
+func (r *RateLimitResult) SetHeaders(headers map[string]string) {
+	headers["ExcDataset-RateLimit-Limit"] = strconv.Itoa(r.Limit)
+	headers["ExcDataset-RateLimit-Remaining"] = strconv.Itoa(r.Remaining)
+	if !r.Allowed {
+		headers["Retry-After"] = strconv.FormatInt(int64(math.Ceil(r.RetryAfter.Seconds())), 10)
+	}
+}
+
+// CheckRateLimit takes a token for the API key, returns 429 response if the API key sent too many requests.
+// Response is nil if the request is allowed, result can be used to set headers of the response.
+// It should be called after the API key is checked to exist, otherwise made up keys can fill the limiter.
+func (a *APIKey) CheckRateLimit(ctx context.Context, limiter RateLimiter) (response *events.APIGatewayProxyResponse, result *RateLimitResult, err error) {
+	if len(a.Key) == 0 {
+		return nil, nil, errors.New("CheckRateLimit: API key is empty")
+	}
+	result, serr := limiter.Allow(ctx, a.Key)
+	if serr != nil {
+		return nil, nil, fmt.Errorf("CheckRateLimit: %v", serr)
+	}
+	if result.Allowed {
+		return nil, result, nil
+	}
+	response = MakeResponse(429, "Too many requests")
+	result.SetHeaders(response.Headers)
+	return response, result, nil
+}
@@ -515,3 +515,31 @@ func newBinanceSimulator(channelFilter []string) *binanceSimulator {
 	s.orderBooks = make(map[string]*binanceOrderbook)
 	return s
 }
+
+func (s *binanceSimulator) OrderBookChannels() []string {
+	channels := make([]string, 0, len(s.orderBooks))
+	for _, symbol := range s.sortOrderbooksBySymbol() {
+		channels = append(channels, symbol+"@"+streamcommons.BinanceStreamRESTDepth)
+	}
+	return channels
+}
+
+func (s *binanceSimulator) OrderBook(channel string) (*OrderBook, error) {
+	// Both depth stream and REST depth channels share the same orderbook
+	symbol, stream, serr := streamcommons.BinanceDecomposeChannel(channel)
+	if serr != nil {
+		return nil, fmt.Errorf("OrderBook: %v", serr)
+	}
+	if stream != streamcommons.BinanceStreamDepth && stream != streamcommons.BinanceStreamRESTDepth {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	memOrderbook, ok := s.orderBooks[symbol]
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = strings.ToUpper(symbol)
+	book.Bids = orderBookLevelsFromMap(memOrderbook.Bids, true)
+	book.Asks = orderBookLevelsFromMap(memOrderbook.Asks, false)
+	return book, nil
+}
@@ -20,6 +20,20 @@ type bitbankOrderbook struct {
 	lastChangeTimestamp time.Time
 }
 
+// bitbankOrderbookState is the json representation of bitbankOrderbook used in state lines.
+type bitbankOrderbookState struct {
+	Asks                [][2]float64 `json:"asks"`
+	Bids                [][2]float64 `json:"bids"`
+	LastChangeTimestamp int64        `json:"lastChangeTimestamp"`
+}
+
+func newBitbankOrderbook() *bitbankOrderbook {
+	orderbook := new(bitbankOrderbook)
+	orderbook.asks = make(map[float64]float64)
+	orderbook.bids = make(map[float64]float64)
+	return orderbook
+}
+
 type bitbankSimulator struct {
 	filterChannel map[string]bool
 	subscribed    []string
@@ -47,18 +61,16 @@ func (s *bitbankSimulator) ProcessSend(line []byte) (channel string, err error)
 		}
 	}
 	s.subscribed = append(s.subscribed, channel)
-	if strings.HasPrefix(channel, "depth_whole_") {
-		pair := channel[len("depth_whole_"):]
-		orderbook := new(bitbankOrderbook)
-		orderbook.asks = make(map[float64]float64)
-		orderbook.bids = make(map[float64]float64)
-		s.orderbook[pair] = orderbook
-	} else if strings.HasPrefix(channel, "depth_diff_") {
-		pair := channel[len("depth_diff_"):]
-		orderbook := new(bitbankOrderbook)
-		orderbook.asks = make(map[float64]float64)
-		orderbook.bids = make(map[float64]float64)
-		s.orderbook[pair] = orderbook
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
+		pair := channel[len(streamcommons.BitbankChannelPrefixDepthWhole):]
+		if _, ok := s.orderbook[pair]; !ok {
+			s.orderbook[pair] = newBitbankOrderbook()
+		}
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff) {
+		pair := channel[len(streamcommons.BitbankChannelPrefixDepthDiff):]
+		if _, ok := s.orderbook[pair]; !ok {
+			s.orderbook[pair] = newBitbankOrderbook()
+		}
 	}
 	return
 }
@@ -73,21 +85,34 @@ func (s *bitbankSimulator) processDepthSide(orderbook map[float64]float64, order
 		if serr != nil {
 			return fmt.Errorf("amount: %v", serr)
 		}
-		orderbook[price] = amount
+		if amount == 0 {
+			// Remove order from the book
+			delete(orderbook, price)
+		} else {
+			orderbook[price] = amount
+		}
 	}
 	return nil
 }
 
 func (s *bitbankSimulator) ProcessMessageWebSocket(line []byte) (channel string, err error) {
 	channel = streamcommons.ChannelUnknown
+	if len(line) == 0 {
+		err = errors.New("empty line")
+		return
+	}
 	if line[0] == '0' {
 		// Welcome message
-		channel = "welcome"
+		channel = streamcommons.BitbankChannelWelcome
 		return
 	}
 	if bytes.HasPrefix(line, []byte("40")) {
 		// Connected message?
-		channel = "connected"
+		channel = streamcommons.BitbankChannelConnected
+		return
+	}
+	if !bytes.HasPrefix(line, []byte("42")) {
+		// Other socket.io control messages such as ping and pong
 		return
 	}
 	// Raw line includes socketio constant 42
@@ -104,7 +129,7 @@ func (s *bitbankSimulator) ProcessMessageWebSocket(line []byte) (channel string,
 		err = fmt.Errorf("msgType unmarshal: %v", serr)
 		return
 	}
-	if *msgType != "message" {
+	if *msgType != jsonstructs.BitbankEventMessage {
 		err = errors.New("wrapper but not message")
 		return
 	}
@@ -121,39 +146,53 @@ func (s *bitbankSimulator) ProcessMessageWebSocket(line []byte) (channel string,
 			return
 		}
 	}
-	if strings.HasPrefix(channel, "depth_whole_") {
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
 		// Depth whole channel
-		pair := channel[len("depth_whole_"):]
+		pair := channel[len(streamcommons.BitbankChannelPrefixDepthWhole):]
+		msg := new(jsonstructs.BitbankMessage)
+		serr := json.Unmarshal(root.Message, msg)
+		if serr != nil {
+			err = fmt.Errorf("message unmarshal: %v", serr)
+			return
+		}
 		depthWhole := new(jsonstructs.BitbankDepthWhole)
-		serr := json.Unmarshal(root.Message, depthWhole)
+		serr = json.Unmarshal(msg.Data, depthWhole)
 		if serr != nil {
 			err = fmt.Errorf("depth whole unmarshal: %v", serr)
 			return
 		}
 		// Reset orderbook
-		orderbook := new(bitbankOrderbook)
-		orderbook.asks = make(map[float64]float64)
-		orderbook.bids = make(map[float64]float64)
+		orderbook := newBitbankOrderbook()
 		err = s.processDepthSide(orderbook.asks, depthWhole.Asks)
 		if err != nil {
 			return
 		}
-		err = s.processDepthSide(orderbook.asks, depthWhole.Bids)
+		err = s.processDepthSide(orderbook.bids, depthWhole.Bids)
 		if err != nil {
 			return
 		}
 		orderbook.lastChangeTimestamp = unixMillisec(depthWhole.Timestamp)
 		s.orderbook[pair] = orderbook
-	} else if strings.HasPrefix(channel, "depth_diff_") {
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff) {
 		// Depth diff channel
-		pair := channel[len("depth_diff_"):]
+		pair := channel[len(streamcommons.BitbankChannelPrefixDepthDiff):]
+		msg := new(jsonstructs.BitbankMessage)
+		serr := json.Unmarshal(root.Message, msg)
+		if serr != nil {
+			err = fmt.Errorf("message unmarshal: %v", serr)
+			return
+		}
 		depthDiff := new(jsonstructs.BitbankDepthDiff)
-		serr := json.Unmarshal(root.Message, depthDiff)
+		serr = json.Unmarshal(msg.Data, depthDiff)
 		if serr != nil {
 			err = fmt.Errorf("depth diff unmarshal: %v", serr)
 			return
 		}
-		orderbook := s.orderbook[pair]
+		orderbook, ok := s.orderbook[pair]
+		if !ok {
+			orderbook = newBitbankOrderbook()
+			s.orderbook[pair] = orderbook
+		}
 		err = s.processDepthSide(orderbook.asks, depthDiff.Asks)
 		if err != nil {
 			return
@@ -207,14 +246,22 @@ func (s *bitbankSimulator) ProcessState(channel string, line []byte) (err error)
 			return
 		}
 	}
-	if strings.HasPrefix(channel, "depth_whole_") {
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
 		// Depth whole state (orderbook state)
-		pair := channel[len("depth_whole_"):]
-		orderbook := new(bitbankOrderbook)
-		serr := json.Unmarshal(line, orderbook)
+		pair := channel[len(streamcommons.BitbankChannelPrefixDepthWhole):]
+		state := new(bitbankOrderbookState)
+		serr := json.Unmarshal(line, state)
 		if serr != nil {
 			return fmt.Errorf("state depth whole unmarshal: %v", serr)
 		}
+		orderbook := newBitbankOrderbook()
+		for _, order := range state.Asks {
+			orderbook.asks[order[0]] = order[1]
+		}
+		for _, order := range state.Bids {
+			orderbook.bids[order[0]] = order[1]
+		}
+		orderbook.lastChangeTimestamp = unixMillisec(state.LastChangeTimestamp)
 		s.orderbook[pair] = orderbook
 	}
 	return
@@ -235,23 +282,43 @@ func (s *bitbankSimulator) TakeStateSnapshot() ([]Snapshot, error) {
 		Snapshot: subMar,
 	})
 	// Depth whole snapshots
-	for pair, orderbook := range s.orderbook {
-		orderbookMar, serr := json.Marshal(orderbook)
+	for _, pair := range s.sortOrderbooksByPair() {
+		orderbook := s.orderbook[pair]
+		state := new(bitbankOrderbookState)
+		state.Asks = make([][2]float64, 0, len(orderbook.asks))
+		for price, amount := range orderbook.asks {
+			state.Asks = append(state.Asks, [2]float64{price, amount})
+		}
+		state.Bids = make([][2]float64, 0, len(orderbook.bids))
+		for price, amount := range orderbook.bids {
+			state.Bids = append(state.Bids, [2]float64{price, amount})
+		}
+		state.LastChangeTimestamp = orderbook.lastChangeTimestamp.UnixNano() / int64(time.Millisecond)
+		orderbookMar, serr := json.Marshal(state)
 		if serr != nil {
 			return nil, fmt.Errorf("orderbook marshal: %v", serr)
 		}
 		snapshots = append(snapshots, Snapshot{
-			Channel:  "depth_whole_" + pair,
+			Channel:  streamcommons.BitbankChannelPrefixDepthWhole + pair,
 			Snapshot: orderbookMar,
 		})
 	}
 	return snapshots, nil
 }
 
+func (s *bitbankSimulator) sortOrderbooksByPair() []string {
+	sorted := make([]string, 0, len(s.orderbook))
+	for pair := range s.orderbook {
+		sorted = append(sorted, pair)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
 func (s *bitbankSimulator) convertOrderbookSide(m map[float64]float64, reverse bool) [][]string {
 	keys := make([]float64, len(m))
 	i := 0
-	for _, key := range m {
+	for key := range m {
 		keys[i] = key
 		i++
 	}
@@ -263,7 +330,7 @@ func (s *bitbankSimulator) convertOrderbookSide(m map[float64]float64, reverse b
 	converted := make([][]string, len(m))
 	for i, price := range keys {
 		priceStr := strconv.FormatFloat(price, 'f', 8, 64)
-		amountStr := strconv.FormatFloat(price, 'f', 8, 64)
+		amountStr := strconv.FormatFloat(m[price], 'f', 8, 64)
 		converted[i] = []string{priceStr, amountStr}
 	}
 	return converted
@@ -271,7 +338,9 @@ func (s *bitbankSimulator) convertOrderbookSide(m map[float64]float64, reverse b
 
 func (s *bitbankSimulator) TakeSnapshot() ([]Snapshot, error) {
 	snapshots := make([]Snapshot, 0, 100)
-	for pair, orderbook := range s.orderbook {
+	for _, pair := range s.sortOrderbooksByPair() {
+		orderbook := s.orderbook[pair]
+		channel := streamcommons.BitbankChannelPrefixDepthWhole + pair
 		depthWhole := new(jsonstructs.BitbankDepthWhole)
 		depthWhole.Asks = s.convertOrderbookSide(orderbook.asks, false)
 		depthWhole.Bids = s.convertOrderbookSide(orderbook.bids, true)
@@ -280,11 +349,29 @@ func (s *bitbankSimulator) TakeSnapshot() ([]Snapshot, error) {
 		if serr != nil {
 			return nil, fmt.Errorf("depth whole marshal: %v", serr)
 		}
-		depthWholeLine := make([]byte, len(depthWholeMar)+2)
+		// Wrap it the same way as bitbank does
+		msgMar, serr := json.Marshal(jsonstructs.BitbankMessage{Data: depthWholeMar})
+		if serr != nil {
+			return nil, fmt.Errorf("message marshal: %v", serr)
+		}
+		rootMar, serr := json.Marshal(jsonstructs.BitbankRoot{RoomName: channel, Message: msgMar})
+		if serr != nil {
+			return nil, fmt.Errorf("root marshal: %v", serr)
+		}
+		msgType, serr := json.Marshal(jsonstructs.BitbankEventMessage)
+		if serr != nil {
+			return nil, fmt.Errorf("msgType marshal: %v", serr)
+		}
+		wrapperMar, serr := json.Marshal(jsonstructs.BitbankWrapper{msgType, rootMar})
+		if serr != nil {
+			return nil, fmt.Errorf("wrapper marshal: %v", serr)
+		}
+		// Prepend socketio constant 42
+		depthWholeLine := make([]byte, len(wrapperMar)+2)
 		depthWholeLine[0], depthWholeLine[1] = '4', '2'
-		copy(depthWholeLine[2:], depthWholeMar)
+		copy(depthWholeLine[2:], wrapperMar)
 		snapshots = append(snapshots, Snapshot{
-			Channel:  "depth_whole_" + pair,
+			Channel:  channel,
 			Snapshot: depthWholeLine,
 		})
 	}
@@ -303,3 +390,32 @@ func newBitbankSimulator(filterChannel []string) *bitbankSimulator {
 	s.subscribed = make([]string, 0)
 	return s
 }
+
+func (s *bitbankSimulator) OrderBookChannels() []string {
+	channels := make([]string, 0, len(s.orderbook))
+	for _, pair := range s.sortOrderbooksByPair() {
+		channels = append(channels, streamcommons.BitbankChannelPrefixDepthWhole+pair)
+	}
+	return channels
+}
+
+func (s *bitbankSimulator) OrderBook(channel string) (*OrderBook, error) {
+	// Both depth whole and depth diff channels share the same orderbook
+	var pair string
+	if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthWhole) {
+		pair = channel[len(streamcommons.BitbankChannelPrefixDepthWhole):]
+	} else if strings.HasPrefix(channel, streamcommons.BitbankChannelPrefixDepthDiff) {
+		pair = channel[len(streamcommons.BitbankChannelPrefixDepthDiff):]
+	} else {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	orderbook, ok := s.orderbook[pair]
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = pair
+	book.Bids = orderBookLevelsFromMap(orderbook.bids, true)
+	book.Asks = orderBookLevelsFromMap(orderbook.asks, false)
+	return book, nil
+}
@@ -21,6 +21,7 @@ type bitfinexBookElement struct {
 type bitfinexSimulator struct {
 	filterChannel map[string]bool
 	// map[chanID]channel
+	// chanID is assigned by the server on response, preserved in the subscribed state line
 	idvch      map[int]string
 	subscribed []int
 	// map[channel]map[price]order
@@ -376,3 +377,29 @@ func newBitfinexSimulator(filterChannel []string) Simulator {
 	gen.orderBooks = make(map[string]map[float64]bitfinexBookElement)
 	return &gen
 }
+
+func (s *bitfinexSimulator) OrderBookChannels() []string {
+	return sortBitfinexBooks(s.orderBooks)
+}
+
+func (s *bitfinexSimulator) OrderBook(channel string) (*OrderBook, error) {
+	memOrderBook, ok := s.orderBooks[channel]
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = channel[len(streamcommons.BitfinexChannelPrefixBook):]
+	book.Bids = make([]OrderBookLevel, 0, len(memOrderBook))
+	book.Asks = make([]OrderBookLevel, 0, len(memOrderBook))
+	for price, elem := range memOrderBook {
+		// Negative amount means ask
+		if elem.amount < 0 {
+			book.Asks = append(book.Asks, OrderBookLevel{Price: price, Size: -elem.amount, Count: int64(elem.count)})
+		} else {
+			book.Bids = append(book.Bids, OrderBookLevel{Price: price, Size: elem.amount, Count: int64(elem.count)})
+		}
+	}
+	sortOrderBookLevels(book.Bids, true)
+	sortOrderBookLevels(book.Asks, false)
+	return book, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/exchangedataset/streamcommons"
 	"github.com/exchangedataset/streamcommons/jsonstructs"
@@ -14,14 +15,108 @@ type bitflyerSimulator struct {
 	filterChannel map[string]bool
 
 	// id versus channel, channels subscribe message has been sent whether or not it actually subscribed map[messageID]channel
-	// FIXME idvch is not preserved: should add new state line
+	// Ones that have not been responded yet are preserved in state line
 	idvch map[int]string
 	// map[messageID]channel
 	subscribed []string
-	// map[channel]map[side]map[price]size
+	// map[productCode]map[side]map[price]size
+	// Both lightning_board_snapshot and lightning_board channels of the same product share the orderbook
 	orderBooks map[string]map[string]map[float64]float64
 }
 
+const bitflyerSideAsk = "asks"
+const bitflyerSideBid = "bids"
+
+// bitflyerBoardProductCode returns the product code of the board channel and true,
+// or false if the channel is not a board channel.
+func bitflyerBoardProductCode(channel string) (string, bool) {
+	if strings.HasPrefix(channel, streamcommons.BitflyerchannelPrefixLightningBoardSnapshot) {
+		return channel[len(streamcommons.BitflyerchannelPrefixLightningBoardSnapshot):], true
+	} else if strings.HasPrefix(channel, streamcommons.BitflyerChannelPrefixLightningBoard) {
+		return channel[len(streamcommons.BitflyerChannelPrefixLightningBoard):], true
+	}
+	return "", false
+}
+
+// isBoardTracked returns true if the orderbook of the product should be tracked.
+// Snapshot channel is needed to track the orderbook even if only the board channel is in the filter.
+func (s *bitflyerSimulator) isBoardTracked(productCode string) bool {
+	if s.filterChannel == nil {
+		return true
+	}
+	_, okSnapshot := s.filterChannel[streamcommons.BitflyerchannelPrefixLightningBoardSnapshot+productCode]
+	_, okBoard := s.filterChannel[streamcommons.BitflyerChannelPrefixLightningBoard+productCode]
+	return okSnapshot || okBoard
+}
+
+func (s *bitflyerSimulator) processBoardSide(book map[float64]float64, orders []jsonstructs.BitflyerBoardParamsMessageOrder) {
+	for _, order := range orders {
+		if order.Size == 0 {
+			// Size is 0 if the order is due to be removed
+			delete(book, order.Price)
+		} else {
+			book[order.Price] = order.Size
+		}
+	}
+}
+
+// processBoard applies board message to the orderbook of the product.
+// If it is a snapshot, orderbook is reset before applying.
+func (s *bitflyerSimulator) processBoard(productCode string, snapshot bool, board *jsonstructs.BitflyerBoardParamsMessage) {
+	sides, ok := s.orderBooks[productCode]
+	if !ok || snapshot {
+		sides = make(map[string]map[float64]float64)
+		sides[bitflyerSideAsk] = make(map[float64]float64)
+		sides[bitflyerSideBid] = make(map[float64]float64)
+		s.orderBooks[productCode] = sides
+	}
+	s.processBoardSide(sides[bitflyerSideAsk], board.Asks)
+	s.processBoardSide(sides[bitflyerSideBid], board.Bids)
+}
+
+// boardMessage reconstructs the whole orderbook of the product as a board message.
+func (s *bitflyerSimulator) boardMessage(productCode string) *jsonstructs.BitflyerBoardParamsMessage {
+	sides := s.orderBooks[productCode]
+	board := new(jsonstructs.BitflyerBoardParamsMessage)
+	asks := sides[bitflyerSideAsk]
+	board.Asks = make([]jsonstructs.BitflyerBoardParamsMessageOrder, 0, len(asks))
+	for _, price := range sortBitflyerSide(asks, false) {
+		board.Asks = append(board.Asks, jsonstructs.BitflyerBoardParamsMessageOrder{Price: price, Size: asks[price]})
+	}
+	bids := sides[bitflyerSideBid]
+	board.Bids = make([]jsonstructs.BitflyerBoardParamsMessageOrder, 0, len(bids))
+	for _, price := range sortBitflyerSide(bids, true) {
+		board.Bids = append(board.Bids, jsonstructs.BitflyerBoardParamsMessageOrder{Price: price, Size: bids[price]})
+	}
+	return board
+}
+
+func sortBitflyerSide(m map[float64]float64, reverse bool) []float64 {
+	keys := make([]float64, len(m))
+	i := 0
+	for k := range m {
+		keys[i] = k
+		i++
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.Float64Slice(keys)))
+	} else {
+		sort.Float64s(keys)
+	}
+	return keys
+}
+
+func sortBitflyerOrderBooks(m map[string]map[string]map[float64]float64) []string {
+	keys := make([]string, len(m))
+	i := 0
+	for k := range m {
+		keys[i] = k
+		i++
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (s *bitflyerSimulator) ProcessStart(line []byte) error {
 	return nil
 }
@@ -59,12 +154,32 @@ func (s *bitflyerSimulator) ProcessMessageWebSocket(line []byte) (channel string
 		s.subscribed = append(s.subscribed, channel)
 		return
 	}
+	if subscribedUnmarshaled.Error != nil {
+		// Subscription failed, the request is no longer pending
+		if ch, ok := s.idvch[subscribedUnmarshaled.ID]; ok {
+			channel = ch
+			delete(s.idvch, subscribedUnmarshaled.ID)
+		}
+		return
+	}
 	root := new(jsonstructs.BitflyerRoot)
 	err = json.Unmarshal(line, root)
 	if err != nil {
 		return
 	}
 	channel = root.Params.Channel
+	if productCode, ok := bitflyerBoardProductCode(channel); ok {
+		if !s.isBoardTracked(productCode) {
+			return
+		}
+		board := new(jsonstructs.BitflyerBoardParamsMessage)
+		err = json.Unmarshal(root.Params.Message, board)
+		if err != nil {
+			return
+		}
+		snapshot := strings.HasPrefix(channel, streamcommons.BitflyerchannelPrefixLightningBoardSnapshot)
+		s.processBoard(productCode, snapshot, board)
+	}
 	return
 }
 
@@ -98,10 +213,33 @@ func (s *bitflyerSimulator) ProcessState(channel string, line []byte) (err error
 		}
 		return
 	}
+	if channel == streamcommons.StateChannelSubscribing {
+		subscribing := make(jsonstructs.BitflyerStateSubscribing)
+		err = json.Unmarshal(line, &subscribing)
+		if err != nil {
+			return
+		}
+		// Filter will be applied when the response arrives
+		for id, subChannel := range subscribing {
+			s.idvch[id] = subChannel
+		}
+		return
+	}
+	productCode, isBoard := bitflyerBoardProductCode(channel)
 	if s.filterChannel != nil {
-		if _, ok := s.filterChannel[channel]; !ok {
+		// Orderbook state is needed if any board channel of the product is in the filter
+		if _, ok := s.filterChannel[channel]; !ok && !(isBoard && s.isBoardTracked(productCode)) {
+			return
+		}
+	}
+	if isBoard {
+		// Orderbook state is in the same format as a board message
+		board := new(jsonstructs.BitflyerBoardParamsMessage)
+		err = json.Unmarshal(line, board)
+		if err != nil {
 			return
 		}
+		s.processBoard(productCode, true, board)
 	}
 	return nil
 }
@@ -123,6 +261,38 @@ func (s *bitflyerSimulator) TakeStateSnapshot() (snapshots []Snapshot, err error
 		Channel:  streamcommons.StateChannelSubscribed,
 		Snapshot: subscribedMarshaled,
 	})
+	// snapshot subscribe requests which have not been responded yet
+	subscribedSet := make(map[string]bool)
+	for _, channel := range s.subscribed {
+		subscribedSet[channel] = true
+	}
+	subscribing := make(jsonstructs.BitflyerStateSubscribing)
+	for id, channel := range s.idvch {
+		if !subscribedSet[channel] {
+			subscribing[id] = channel
+		}
+	}
+	var subscribingMarshaled []byte
+	subscribingMarshaled, err = json.Marshal(subscribing)
+	if err != nil {
+		return
+	}
+	snapshots = append(snapshots, Snapshot{
+		Channel:  streamcommons.StateChannelSubscribing,
+		Snapshot: subscribingMarshaled,
+	})
+	// snapshot orderbooks
+	for _, productCode := range sortBitflyerOrderBooks(s.orderBooks) {
+		var boardMarshaled []byte
+		boardMarshaled, err = json.Marshal(s.boardMessage(productCode))
+		if err != nil {
+			return
+		}
+		snapshots = append(snapshots, Snapshot{
+			Channel:  streamcommons.BitflyerchannelPrefixLightningBoardSnapshot + productCode,
+			Snapshot: boardMarshaled,
+		})
+	}
 	return
 }
 
@@ -145,6 +315,42 @@ func (s *bitflyerSimulator) TakeSnapshot() (snapshots []Snapshot, err error) {
 		}
 		snapshots = append(snapshots, Snapshot{Channel: channel, Snapshot: marshaled})
 	}
+
+	// generate board snapshot message for board channels
+	subscribedSet := make(map[string]bool)
+	for _, channel := range sortedSubscibed {
+		subscribedSet[channel] = true
+	}
+	for _, channel := range sortedSubscibed {
+		productCode, ok := bitflyerBoardProductCode(channel)
+		if !ok {
+			continue
+		}
+		if _, ok := s.orderBooks[productCode]; !ok {
+			continue
+		}
+		// The whole orderbook is a snapshot, it goes to the board channel of diffs
+		// only if the snapshot channel of the product is not subscribed
+		if !strings.HasPrefix(channel, streamcommons.BitflyerchannelPrefixLightningBoardSnapshot) &&
+			subscribedSet[streamcommons.BitflyerchannelPrefixLightningBoardSnapshot+productCode] {
+			continue
+		}
+		var boardMarshaled []byte
+		boardMarshaled, err = json.Marshal(s.boardMessage(productCode))
+		if err != nil {
+			return
+		}
+		root := new(jsonstructs.BitflyerRoot)
+		root.Initialize()
+		root.Params.Channel = channel
+		root.Params.Message = boardMarshaled
+		var marshaled []byte
+		marshaled, err = json.Marshal(root)
+		if err != nil {
+			return
+		}
+		snapshots = append(snapshots, Snapshot{Channel: channel, Snapshot: marshaled})
+	}
 	return
 }
 
@@ -158,5 +364,37 @@ func newBitflyerSimulator(filterChannel []string) Simulator {
 	}
 	gen.idvch = make(map[int]string)
 	gen.subscribed = make([]string, 0)
+	gen.orderBooks = make(map[string]map[string]map[float64]float64)
 	return &gen
 }
+
+func (s *bitflyerSimulator) OrderBookChannels() []string {
+	channels := make([]string, 0, len(s.orderBooks))
+	for _, channel := range s.subscribed {
+		productCode, ok := bitflyerBoardProductCode(channel)
+		if !ok {
+			continue
+		}
+		if _, ok := s.orderBooks[productCode]; ok {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (s *bitflyerSimulator) OrderBook(channel string) (*OrderBook, error) {
+	productCode, ok := bitflyerBoardProductCode(channel)
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	sides, ok := s.orderBooks[productCode]
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = productCode
+	book.Bids = orderBookLevelsFromMap(sides[bitflyerSideBid], true)
+	book.Asks = orderBookLevelsFromMap(sides[bitflyerSideAsk], false)
+	return book, nil
+}
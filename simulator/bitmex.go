@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/exchangedataset/streamcommons"
 	"github.com/exchangedataset/streamcommons/jsonstructs"
@@ -374,3 +375,36 @@ func newBitmexSimulator(filterChannels []string) Simulator {
 	gen.orderBooks = make(map[string]map[string]map[int64]bitmexOrderBookL2Element)
 	return &gen
 }
+
+func (s *bitmexSimulator) OrderBookChannels() []string {
+	channels := make([]string, 0, len(s.orderBooks))
+	for _, symbol := range sortBitmexOrderbooks(s.orderBooks) {
+		channels = append(channels, streamcommons.BitmexChannelOrderBookL2+"_"+symbol)
+	}
+	return channels
+}
+
+func (s *bitmexSimulator) OrderBook(channel string) (*OrderBook, error) {
+	if !strings.HasPrefix(channel, streamcommons.BitmexChannelOrderBookL2+"_") {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	symbol := channel[len(streamcommons.BitmexChannelOrderBookL2)+1:]
+	sides, ok := s.orderBooks[symbol]
+	if !ok {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = symbol
+	// Each element in orderBookL2 is a price level
+	book.Bids = make([]OrderBookLevel, 0, len(sides[BitmexSideBuy]))
+	for _, elem := range sides[BitmexSideBuy] {
+		book.Bids = append(book.Bids, OrderBookLevel{Price: elem.price, Size: float64(elem.size)})
+	}
+	sortOrderBookLevels(book.Bids, true)
+	book.Asks = make([]OrderBookLevel, 0, len(sides[BitmexSideSell]))
+	for _, elem := range sides[BitmexSideSell] {
+		book.Asks = append(book.Asks, OrderBookLevel{Price: elem.price, Size: float64(elem.size)})
+	}
+	sortOrderBookLevels(book.Asks, false)
+	return book, nil
+}
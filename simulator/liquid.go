@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/exchangedataset/streamcommons"
 	"github.com/exchangedataset/streamcommons/jsonstructs"
@@ -12,6 +15,9 @@ import (
 type liquidSimulator struct {
 	channelFilter map[string]bool
 	subscribed    []string
+	// Price ladder is a full replacement, so the latest one is kept as it is
+	// map[channel]ladder
+	ladders map[string]jsonstructs.LiquidPriceLadder
 }
 
 func (s *liquidSimulator) ProcessStart(line []byte) (err error) {
@@ -58,6 +64,29 @@ func (s *liquidSimulator) ProcessMessageWebSocket(line []byte) (channel string,
 			}
 		}
 		s.subscribed = append(s.subscribed, channel)
+		return
+	}
+	if strings.HasPrefix(channel, streamcommons.LiquidChannelPrefixLaddersCash) {
+		if s.channelFilter != nil {
+			_, ok := s.channelFilter[channel]
+			if !ok {
+				return
+			}
+		}
+		// Data for ladder is encoded in string
+		dataStr := new(string)
+		serr := json.Unmarshal(r.Data, dataStr)
+		if serr != nil {
+			err = fmt.Errorf("price ladder dataStr unmarshal: %v", serr)
+			return
+		}
+		ladder := make(jsonstructs.LiquidPriceLadder, 0, 100)
+		serr = json.Unmarshal([]byte(*dataStr), &ladder)
+		if serr != nil {
+			err = fmt.Errorf("price ladder unmarshal: %v", serr)
+			return
+		}
+		s.ladders[channel] = ladder
 	}
 	return
 }
@@ -78,7 +107,24 @@ func (s *liquidSimulator) ProcessMessageChannelKnown(channel string, line []byte
 }
 
 func (s *liquidSimulator) ProcessState(channel string, line []byte) (err error) {
-	// Liquid has only subscribe state
+	if strings.HasPrefix(channel, streamcommons.LiquidChannelPrefixLaddersCash) {
+		if s.channelFilter != nil {
+			_, ok := s.channelFilter[channel]
+			if !ok {
+				return nil
+			}
+		}
+		ladder := make(jsonstructs.LiquidPriceLadder, 0, 100)
+		serr := json.Unmarshal(line, &ladder)
+		if serr != nil {
+			return fmt.Errorf("price ladder unmarshal: %v", serr)
+		}
+		s.ladders[channel] = ladder
+		return nil
+	}
+	if channel != streamcommons.StateChannelSubscribed {
+		return nil
+	}
 	subscribed := make([]string, 0, 100)
 	serr := json.Unmarshal(line, &subscribed)
 	if serr != nil {
@@ -105,16 +151,35 @@ func (s *liquidSimulator) TakeStateSnapshot() ([]Snapshot, error) {
 	if serr != nil {
 		return nil, fmt.Errorf("subscribed marshal: %v", serr)
 	}
-	return []Snapshot{
-		Snapshot{
-			Channel:  streamcommons.StateChannelSubscribed,
-			Snapshot: subm,
-		},
-	}, nil
+	snapshots := make([]Snapshot, 0, 1+len(s.ladders))
+	snapshots = append(snapshots, Snapshot{
+		Channel:  streamcommons.StateChannelSubscribed,
+		Snapshot: subm,
+	})
+	for _, ch := range s.sortLaddersByChannel() {
+		lm, serr := json.Marshal(s.ladders[ch])
+		if serr != nil {
+			return nil, fmt.Errorf("price ladder marshal: %v", serr)
+		}
+		snapshots = append(snapshots, Snapshot{
+			Channel:  ch,
+			Snapshot: lm,
+		})
+	}
+	return snapshots, nil
+}
+
+func (s *liquidSimulator) sortLaddersByChannel() []string {
+	sorted := make([]string, 0, len(s.ladders))
+	for ch := range s.ladders {
+		sorted = append(sorted, ch)
+	}
+	sort.Strings(sorted)
+	return sorted
 }
 
 func (s *liquidSimulator) TakeSnapshot() ([]Snapshot, error) {
-	snapshots := make([]Snapshot, len(s.subscribed))
+	snapshots := make([]Snapshot, len(s.subscribed), len(s.subscribed)+len(s.ladders))
 	for i, ch := range s.subscribed {
 		s := new(jsonstructs.LiquidSubscribeData)
 		s.Channel = ch
@@ -135,6 +200,29 @@ func (s *liquidSimulator) TakeSnapshot() ([]Snapshot, error) {
 			Snapshot: rm,
 		}
 	}
+	for _, ch := range s.sortLaddersByChannel() {
+		lm, serr := json.Marshal(s.ladders[ch])
+		if serr != nil {
+			return nil, fmt.Errorf("price ladder marshal: %v", serr)
+		}
+		// Liquid encodes ladder in string
+		dm, serr := json.Marshal(string(lm))
+		if serr != nil {
+			return nil, fmt.Errorf("price ladder data marshal: %v", serr)
+		}
+		r := new(jsonstructs.LiquidMessageRoot)
+		r.Channel = &ch
+		r.Data = dm
+		r.Event = jsonstructs.LiquidEventUpdated
+		rm, serr := json.Marshal(r)
+		if serr != nil {
+			return nil, fmt.Errorf("price ladder root marshal: %v", serr)
+		}
+		snapshots = append(snapshots, Snapshot{
+			Channel:  ch,
+			Snapshot: rm,
+		})
+	}
 	return snapshots, nil
 }
 
@@ -147,5 +235,61 @@ func newLiquidSimulator(channelFilter []string) *liquidSimulator {
 		}
 	}
 	s.subscribed = make([]string, 0, 100)
+	s.ladders = make(map[string]jsonstructs.LiquidPriceLadder)
 	return s
 }
+
+func (s *liquidSimulator) OrderBookChannels() []string {
+	return s.sortLaddersByChannel()
+}
+
+// ladderLevels parses a price ladder into levels, nil ladder is regarded as empty.
+func (s *liquidSimulator) ladderLevels(ladder jsonstructs.LiquidPriceLadder, bid bool) ([]OrderBookLevel, error) {
+	levels := make([]OrderBookLevel, len(ladder))
+	for i, order := range ladder {
+		if len(order) < 2 {
+			return nil, errors.New("order has less than 2 elements")
+		}
+		price, serr := strconv.ParseFloat(order[0], 64)
+		if serr != nil {
+			return nil, fmt.Errorf("price: %v", serr)
+		}
+		quantity, serr := strconv.ParseFloat(order[1], 64)
+		if serr != nil {
+			return nil, fmt.Errorf("quantity: %v", serr)
+		}
+		levels[i] = OrderBookLevel{Price: price, Size: quantity}
+	}
+	sortOrderBookLevels(levels, bid)
+	return levels, nil
+}
+
+// OrderBook returns the orderbook of the symbol the channel belongs to.
+// Liquid has separated channels for each side, both sides are merged into one orderbook.
+func (s *liquidSimulator) OrderBook(channel string) (*OrderBook, error) {
+	if !strings.HasPrefix(channel, streamcommons.LiquidChannelPrefixLaddersCash) {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	sideStart := strings.LastIndexByte(channel, '_')
+	if sideStart < len(streamcommons.LiquidChannelPrefixLaddersCash) {
+		return nil, fmt.Errorf("OrderBook: price ladder no underscore in channel")
+	}
+	prefix := channel[:sideStart+1]
+	buy, okBuy := s.ladders[prefix+"buy"]
+	sell, okSell := s.ladders[prefix+"sell"]
+	if !okBuy && !okSell {
+		return nil, errOrderBookNotTracked(channel)
+	}
+	book := new(OrderBook)
+	book.Symbol = channel[len(streamcommons.LiquidChannelPrefixLaddersCash):sideStart]
+	var serr error
+	book.Bids, serr = s.ladderLevels(buy, true)
+	if serr != nil {
+		return nil, fmt.Errorf("OrderBook: buy: %v", serr)
+	}
+	book.Asks, serr = s.ladderLevels(sell, false)
+	if serr != nil {
+		return nil, fmt.Errorf("OrderBook: sell: %v", serr)
+	}
+	return book, nil
+}
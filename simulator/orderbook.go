@@ -0,0 +1,108 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OrderBookLevel is a price level in an orderbook.
+type OrderBookLevel struct {
+	Price float64
+	Size  float64
+	// Count is the number of orders on this level, 0 if the exchange does not provide it.
+	Count int64
+}
+
+// OrderBook is an orderbook of a symbol in the common format.
+// Bids are sorted in descending order, asks in ascending order so that the best price comes first.
+type OrderBook struct {
+	Symbol string
+	Bids   []OrderBookLevel
+	Asks   []OrderBookLevel
+}
+
+// OrderBookProvider is implemented by simulators which track orderbooks.
+// Use type assertion on Simulator to get this.
+type OrderBookProvider interface {
+	// OrderBookChannels returns the sorted list of channels whose orderbook can be queried.
+	OrderBookChannels() []string
+	// OrderBook returns the orderbook for the channel.
+	// Returns error if the orderbook for the channel is not tracked.
+	OrderBook(channel string) (*OrderBook, error)
+}
+
+// Top returns new orderbook with only top n levels on each side, negative n is treated as 0.
+func (b *OrderBook) Top(n int) *OrderBook {
+	if n < 0 {
+		n = 0
+	}
+	top := new(OrderBook)
+	top.Symbol = b.Symbol
+	if n > len(b.Bids) {
+		top.Bids = b.Bids
+	} else {
+		top.Bids = b.Bids[:n]
+	}
+	if n > len(b.Asks) {
+		top.Asks = b.Asks
+	} else {
+		top.Asks = b.Asks[:n]
+	}
+	return top
+}
+
+// BestBid returns the highest bid, ok is false if there are no bids.
+func (b *OrderBook) BestBid() (level OrderBookLevel, ok bool) {
+	if len(b.Bids) == 0 {
+		return
+	}
+	return b.Bids[0], true
+}
+
+// BestAsk returns the lowest ask, ok is false if there are no asks.
+func (b *OrderBook) BestAsk() (level OrderBookLevel, ok bool) {
+	if len(b.Asks) == 0 {
+		return
+	}
+	return b.Asks[0], true
+}
+
+// MidPrice returns the average of the best bid and the best ask, ok is false if either side is empty.
+func (b *OrderBook) MidPrice() (mid float64, ok bool) {
+	bid, ok := b.BestBid()
+	if !ok {
+		return
+	}
+	ask, ok := b.BestAsk()
+	if !ok {
+		return
+	}
+	return (bid.Price + ask.Price) / 2, true
+}
+
+// sortOrderBookLevels sorts levels from the best price.
+func sortOrderBookLevels(levels []OrderBookLevel, bid bool) {
+	if bid {
+		sort.Slice(levels, func(i, j int) bool {
+			return levels[i].Price > levels[j].Price
+		})
+	} else {
+		sort.Slice(levels, func(i, j int) bool {
+			return levels[i].Price < levels[j].Price
+		})
+	}
+}
+
+// orderBookLevelsFromMap converts map[price]size into sorted levels.
+func orderBookLevelsFromMap(m map[float64]float64, bid bool) []OrderBookLevel {
+	levels := make([]OrderBookLevel, 0, len(m))
+	for price, size := range m {
+		levels = append(levels, OrderBookLevel{Price: price, Size: size})
+	}
+	sortOrderBookLevels(levels, bid)
+	return levels
+}
+
+func errOrderBookNotTracked(channel string) error {
+	return fmt.Errorf("orderbook for channel '%s' is not tracked", channel)
+}
@@ -0,0 +1,142 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/exchangedataset/streamcommons"
+)
+
+// LineReader reads lines from a time-ordered dump stream, dump.Reader implements this.
+type LineReader interface {
+	// ReadLine returns io.EOF if there are no more lines.
+	ReadLine() (typ string, timestamp int64, channel string, message []byte, err error)
+}
+
+// ReplayedSnapshot is the snapshots taken at a timestamp.
+type ReplayedSnapshot struct {
+	// Timestamp in unix nanosecond
+	Timestamp int64
+	Snapshots []Snapshot
+}
+
+// Replayer feeds a time-ordered dump stream to a Simulator and takes snapshots at requested timestamps.
+// Snapshot at a timestamp reflects all lines whose timestamp is less than or equal to it.
+type Replayer struct {
+	sim Simulator
+	// Sorted timestamps to take snapshots at
+	timestamps []int64
+	// Index of the next timestamp to take a snapshot at
+	next int
+	// True if the simulator has its initial state, state lines are skipped after this
+	stateApplied bool
+	snapshots    []ReplayedSnapshot
+}
+
+// NewReplayer creates a new Replayer which takes snapshots at the given timestamps.
+func NewReplayer(sim Simulator, timestamps []int64) *Replayer {
+	r := new(Replayer)
+	r.sim = sim
+	r.timestamps = make([]int64, len(timestamps))
+	copy(r.timestamps, timestamps)
+	sort.Slice(r.timestamps, func(i, j int) bool {
+		return r.timestamps[i] < r.timestamps[j]
+	})
+	r.snapshots = make([]ReplayedSnapshot, 0, len(timestamps))
+	return r
+}
+
+// NewIntervalReplayer creates a new Replayer which takes snapshots from start to end (inclusive) with fixed interval.
+func NewIntervalReplayer(sim Simulator, start int64, end int64, interval int64) (*Replayer, error) {
+	if interval <= 0 {
+		return nil, errors.New("NewIntervalReplayer: interval must be positive")
+	}
+	if end < start {
+		return nil, errors.New("NewIntervalReplayer: end is before start")
+	}
+	timestamps := make([]int64, 0, (end-start)/interval+1)
+	for t := start; t <= end; t += interval {
+		timestamps = append(timestamps, t)
+	}
+	return NewReplayer(sim, timestamps), nil
+}
+
+// SkipState tells the replayer the simulator already has its starting state applied,
+// so that state lines in the stream will be ignored.
+func (r *Replayer) SkipState() {
+	r.stateApplied = true
+}
+
+// takeUntil takes snapshots for all timestamps before the given timestamp.
+func (r *Replayer) takeUntil(timestamp int64) error {
+	for ; r.next < len(r.timestamps) && r.timestamps[r.next] < timestamp; r.next++ {
+		snapshots, serr := r.sim.TakeSnapshot()
+		if serr != nil {
+			return fmt.Errorf("take snapshot: %v", serr)
+		}
+		r.snapshots = append(r.snapshots, ReplayedSnapshot{
+			Timestamp: r.timestamps[r.next],
+			Snapshots: snapshots,
+		})
+	}
+	return nil
+}
+
+// Process processes a line in the stream.
+// Only the first contiguous state lines are applied, because the simulator carries the state over
+// while processing the stream and state lines in the succeeding dump files are the same state.
+func (r *Replayer) Process(typ string, timestamp int64, channel string, line []byte) error {
+	if typ == streamcommons.LineTypeState {
+		if r.stateApplied {
+			return nil
+		}
+	} else {
+		r.stateApplied = true
+	}
+	serr := r.takeUntil(timestamp)
+	if serr != nil {
+		return fmt.Errorf("Process: %v", serr)
+	}
+	serr = ProcessLine(r.sim, typ, channel, line)
+	if serr != nil {
+		return fmt.Errorf("Process: %v", serr)
+	}
+	return nil
+}
+
+// Done returns true if snapshots for all timestamps are taken, rest of the stream does not have to be processed.
+func (r *Replayer) Done() bool {
+	return r.next >= len(r.timestamps)
+}
+
+// Replay processes lines from the reader until all snapshots are taken or the stream reaches EOF.
+// It can be called multiple times for consecutive streams.
+func (r *Replayer) Replay(reader LineReader) error {
+	for !r.Done() {
+		typ, timestamp, channel, line, serr := reader.ReadLine()
+		if serr == io.EOF {
+			return nil
+		}
+		if serr != nil {
+			return fmt.Errorf("Replay: %v", serr)
+		}
+		serr = r.Process(typ, timestamp, channel, line)
+		if serr != nil {
+			return fmt.Errorf("Replay: %v", serr)
+		}
+	}
+	return nil
+}
+
+// Finish takes snapshots for the rest of timestamps with the current state and returns all snapshots taken.
+// Call this after the whole stream covering the timestamps is processed.
+func (r *Replayer) Finish() ([]ReplayedSnapshot, error) {
+	serr := r.takeUntil(math.MaxInt64)
+	if serr != nil {
+		return nil, fmt.Errorf("Finish: %v", serr)
+	}
+	return r.snapshots, nil
+}
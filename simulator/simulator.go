@@ -52,11 +52,33 @@ func GetSimulator(exchange string, channels []string) (Simulator, error) {
 		return newBinanceSimulator(channels), nil
 	case streamcommons.ExchangeLiquid:
 		return newLiquidSimulator(channels), nil
+	case streamcommons.ExchangeBitbank:
+		return newBitbankSimulator(channels), nil
 	default:
 		return nil, fmt.Errorf("snapshot for exchange %s is not supported", exchange)
 	}
 }
 
+// ProcessLine feeds a line of dump file to the simulator according to its type.
+// Lines of end and err are ignored.
+func ProcessLine(sim Simulator, typ string, channel string, line []byte) (err error) {
+	switch typ {
+	case streamcommons.LineTypeStart:
+		err = sim.ProcessStart(line)
+	case streamcommons.LineTypeSend:
+		_, err = sim.ProcessSend(line)
+	case streamcommons.LineTypeMessage:
+		if channel == streamcommons.ChannelUnknown {
+			_, err = sim.ProcessMessageWebSocket(line)
+		} else {
+			err = sim.ProcessMessageChannelKnown(channel, line)
+		}
+	case streamcommons.LineTypeState:
+		err = sim.ProcessState(channel, line)
+	}
+	return
+}
+
 // ToSimulatorChannel converts raw channels (user specified) to simulator channels.
 func ToSimulatorChannel(exchange string, rawChannels []string) []string {
 	switch exchange {
@@ -0,0 +1,371 @@
+package streamcommons
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of storage
+const (
+	StorageTypeS3     = "s3"
+	StorageTypeLocal  = "local"
+	StorageTypeMemory = "memory"
+)
+
+// Storage stores objects such as dump files by key.
+type Storage interface {
+	// Get gets the object of the key, returns nil if the key does not exist.
+	// Returned error should be *GetError with Retryable set if the operation could succeed on retry.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange gets length bytes of the object from offset, or to the end if length is negative.
+	// Returns nil if the key does not exist, and empty body if offset is at or after the end.
+	GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	// Stat returns the information of the object, returns nil if the key does not exist.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Put puts the object of the key, overwrites if the key already exists.
+	// opts can be nil for the default options.
+	Put(ctx context.Context, key string, body io.Reader, opts *PutOptions) error
+	// Tags returns the tags of the object, empty if it has no tags.
+	// Returns *GetError with ErrObjectNotFound if the key does not exist.
+	Tags(ctx context.Context, key string) (map[string]string, error)
+	// List lists objects whose key starts with the prefix in lexicographical order of keys.
+	List(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error)
+}
+
+// ErrObjectNotFound is the error for an object which does not exist, used in GetError.
+var ErrObjectNotFound = errors.New("object not found")
+
+// GetError is the error occurred while getting the object of the key.
+type GetError struct {
+	Key string
+	// Retryable is true if the error is temporary such as throttling or server errors
+	Retryable bool
+	Err       error
+}
+
+func (e *GetError) Error() string {
+	return fmt.Sprintf("key '%s': %v", e.Key, e.Err)
+}
+
+// Unwrap returns the cause so that errors.Is(err, ErrObjectNotFound) works.
+func (e *GetError) Unwrap() error {
+	return e.Err
+}
+
+// ObjectInfo is the information of an object in a storage.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag changes when the content changes, empty if the storage does not support it
+	ETag string
+	// Metadata is the user metadata of the object, only returned by Stat
+	Metadata map[string]string
+	// Tags is the tags of the object, only returned by HeadS3Object
+	Tags map[string]string
+}
+
+// ChecksumMetadataKey is the key of the metadata which has hex encoded SHA-256 of the content.
+const ChecksumMetadataKey = "sha256"
+
+// PutOptions is the options for Storage.Put.
+type PutOptions struct {
+	// Metadata is the user metadata stored with the object, keys are converted to lower case
+	Metadata map[string]string
+	// Tags is the tags of the object, s3 allows up to 10 tags
+	Tags map[string]string
+	// Checksum makes SHA-256 of the content computed while writing and stored in metadata
+	// with ChecksumMetadataKey, GetVerified uses it to detect corruption
+	Checksum bool
+	// PartSize is the size of each part in multipart uploads to s3, 0 for the default
+	PartSize int64
+	// Concurrency is the number of parts uploaded to s3 at once, 0 for the default
+	Concurrency int
+}
+
+// ErrChecksumMismatch is returned from readers of GetVerified when the content does not match the checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumReader computes SHA-256 of the content read through it.
+type checksumReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, h: sha256.New()}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *checksumReader) Sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
+
+// putMetadata returns the metadata to store including the checksum if computed.
+func putMetadata(opts *PutOptions, cr *checksumReader) map[string]string {
+	metadata := make(map[string]string)
+	if opts != nil {
+		for k, v := range opts.Metadata {
+			// Keys are case insensitive in s3
+			metadata[strings.ToLower(k)] = v
+		}
+	}
+	if cr != nil {
+		metadata[ChecksumMetadataKey] = cr.Sum()
+	}
+	return metadata
+}
+
+// putTags returns a copy of tags of the options.
+func putTags(opts *PutOptions) map[string]string {
+	tags := make(map[string]string)
+	if opts != nil {
+		for k, v := range opts.Tags {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// verifyingReader returns ErrChecksumMismatch on EOF if the content does not match the checksum.
+type verifyingReader struct {
+	*checksumReader
+	io.Closer
+	key      string
+	expected string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.checksumReader.Read(p)
+	if err == io.EOF && r.Sum() != r.expected {
+		return n, &GetError{Key: r.key, Err: ErrChecksumMismatch}
+	}
+	return n, err
+}
+
+// infoGetter is implemented by storages which can get the object with its information at once,
+// GetVerified prefers it so that the checksum is of the content read even if the object is replaced meanwhile.
+type infoGetter interface {
+	getWithInfo(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+}
+
+// GetVerified gets the object and verifies its content against the checksum stored by Put with Checksum option.
+// Reading the returned body returns *GetError with ErrChecksumMismatch instead of io.EOF if the content is corrupted.
+// Objects without checksum are returned without verification, returns nil if the key does not exist.
+func GetVerified(ctx context.Context, s Storage, key string) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	var info *ObjectInfo
+	var serr error
+	if g, ok := s.(infoGetter); ok {
+		body, info, serr = g.getWithInfo(ctx, key)
+		if serr != nil {
+			return nil, fmt.Errorf("GetVerified: %v", serr)
+		}
+	} else {
+		info, serr = s.Stat(ctx, key)
+		if serr != nil {
+			return nil, fmt.Errorf("GetVerified: %v", serr)
+		}
+		if info == nil {
+			return nil, nil
+		}
+		body, serr = s.Get(ctx, key)
+		if serr != nil {
+			return nil, fmt.Errorf("GetVerified: %v", serr)
+		}
+	}
+	if body == nil {
+		return nil, nil
+	}
+	expected, ok := info.Metadata[ChecksumMetadataKey]
+	if !ok {
+		return body, nil
+	}
+	return &verifyingReader{
+		checksumReader: newChecksumReader(body),
+		Closer:         body,
+		key:            key,
+		expected:       expected,
+	}, nil
+}
+
+// ListOptions is the options for Storage.List.
+// Set StartAfter to the last key of the previous result to get the next page.
+type ListOptions struct {
+	// StartAfter lists only keys after this key
+	StartAfter string
+	// MaxKeys is the maximum number of objects to list, 0 means no limit
+	MaxKeys int
+}
+
+// ObjectRange is the byte range of an object.
+type ObjectRange struct {
+	Key    string
+	Offset int64
+	// Length is the number of bytes from Offset, negative for to the end of the object
+	Length int64
+}
+
+// whole returns true if the range covers the whole object.
+func (r ObjectRange) whole() bool {
+	return r.Offset == 0 && r.Length < 0
+}
+
+// readCloser combines a reader and a closer of its source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// filterObjects filters and sorts objects by the prefix and options, used by storages which list all objects.
+func filterObjects(objects []ObjectInfo, prefix string, opts ListOptions) []ObjectInfo {
+	ret := make([]ObjectInfo, 0, len(objects))
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, prefix) && obj.Key > opts.StartAfter {
+			ret = append(ret, obj)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	if opts.MaxKeys > 0 && len(ret) > opts.MaxKeys {
+		ret = ret[:opts.MaxKeys]
+	}
+	return ret
+}
+
+// StorageConfig is the configuration to create Storage.
+type StorageConfig struct {
+	// Type is one of StorageTypeS3, StorageTypeLocal and StorageTypeMemory
+	Type string
+	// S3 is used by s3 storage
+	S3 S3Config
+	// Directory is the root directory of local storage
+	Directory string
+	// CacheDirectory enables CachedStorage in front of the storage if not empty
+	CacheDirectory string
+	// CacheMaxBytes is the maximum size of the cache
+	CacheMaxBytes int64
+	// Keys is the naming scheme of objects in the storage
+	Keys KeyScheme
+}
+
+// DefaultCacheMaxBytes is the cache size used if it is not specified.
+const DefaultCacheMaxBytes = 1 << 30
+
+// StorageConfigFromEnv reads StorageConfig from environment variables.
+// S3 storage with the bucket of STORAGE_S3_PROFILE is used if not specified, the profile is test by default.
+// STORAGE_S3_REGION and STORAGE_S3_BUCKET override the region and the bucket of the profile.
+func StorageConfigFromEnv() (StorageConfig, error) {
+	config := StorageConfig{
+		Type:      os.Getenv("STORAGE_TYPE"),
+		Directory: os.Getenv("STORAGE_LOCAL_DIRECTORY"),
+		// Cache is disabled if not specified
+		CacheDirectory: os.Getenv("STORAGE_CACHE_DIRECTORY"),
+		CacheMaxBytes:  DefaultCacheMaxBytes,
+		Keys: KeyScheme{
+			Prefix:    os.Getenv("STORAGE_KEY_PREFIX"),
+			Extension: os.Getenv("STORAGE_KEY_EXTENSION"),
+		},
+	}
+	if config.Type == "" {
+		config.Type = StorageTypeS3
+	}
+	if maxBytes := os.Getenv("STORAGE_CACHE_MAX_BYTES"); maxBytes != "" {
+		var serr error
+		config.CacheMaxBytes, serr = strconv.ParseInt(maxBytes, 10, 64)
+		if serr != nil {
+			return config, fmt.Errorf("StorageConfigFromEnv: STORAGE_CACHE_MAX_BYTES: %v", serr)
+		}
+	}
+	profile := os.Getenv("STORAGE_S3_PROFILE")
+	if profile == "" {
+		profile = S3ProfileTest
+	}
+	var serr error
+	config.S3, serr = S3ConfigForProfile(profile)
+	if serr != nil {
+		return config, fmt.Errorf("StorageConfigFromEnv: %v", serr)
+	}
+	if region := os.Getenv("STORAGE_S3_REGION"); region != "" {
+		config.S3.Region = region
+	}
+	if bucket := os.Getenv("STORAGE_S3_BUCKET"); bucket != "" {
+		config.S3.Bucket = bucket
+	}
+	config.S3.Endpoint = os.Getenv("STORAGE_S3_ENDPOINT")
+	if pathStyle := os.Getenv("STORAGE_S3_FORCE_PATH_STYLE"); pathStyle != "" {
+		config.S3.ForcePathStyle, serr = strconv.ParseBool(pathStyle)
+		if serr != nil {
+			return config, fmt.Errorf("StorageConfigFromEnv: STORAGE_S3_FORCE_PATH_STYLE: %v", serr)
+		}
+	}
+	return config, nil
+}
+
+// NewStorage creates new Storage from the configuration.
+func NewStorage(config StorageConfig) (Storage, error) {
+	var s Storage
+	switch config.Type {
+	case StorageTypeS3:
+		s3, serr := NewS3Storage(config.S3)
+		if serr != nil {
+			return nil, fmt.Errorf("NewStorage: %v", serr)
+		}
+		s = s3
+	case StorageTypeLocal:
+		if config.Directory == "" {
+			return nil, fmt.Errorf("NewStorage: directory is not specified for local storage")
+		}
+		s = NewLocalStorage(config.Directory)
+	case StorageTypeMemory:
+		s = NewMemoryStorage()
+	default:
+		return nil, fmt.Errorf("NewStorage: unknown storage type '%s'", config.Type)
+	}
+	if config.CacheDirectory != "" {
+		cs, serr := NewCachedStorage(s, config.CacheDirectory, config.CacheMaxBytes)
+		if serr != nil {
+			return nil, fmt.Errorf("NewStorage: %v", serr)
+		}
+		s = cs
+	}
+	return s, nil
+}
+
+var defaultStorage Storage
+var defaultStorageErr error
+var defaultStorageOnce sync.Once
+
+// DefaultStorage returns the storage created from StorageConfigFromEnv, used by GetS3Object, PutS3Object and S3GetAll.
+// It is created once on the first call, the error is also kept and returned on later calls.
+func DefaultStorage() (Storage, error) {
+	defaultStorageOnce.Do(func() {
+		config, serr := StorageConfigFromEnv()
+		if serr != nil {
+			defaultStorageErr = fmt.Errorf("DefaultStorage: %v", serr)
+			return
+		}
+		defaultStorage, serr = NewStorage(config)
+		if serr != nil {
+			defaultStorageErr = fmt.Errorf("DefaultStorage: %v", serr)
+		}
+	})
+	return defaultStorage, defaultStorageErr
+}
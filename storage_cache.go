@@ -0,0 +1,326 @@
+package streamcommons
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// cacheTempPrefix is the prefix of temporary files being downloaded into the cache directory.
+const cacheTempPrefix = ".tmp-"
+
+// cacheTempMaxAge is the age of temporary files considered to be left by crashed or canceled downloads.
+// Younger ones could be being written by other processes sharing the directory.
+const cacheTempMaxAge = time.Hour
+
+// CacheStats is the statistics of CachedStorage.
+type CacheStats struct {
+	Hits int64
+	// Misses is the number of gets which downloaded the object into the cache
+	Misses int64
+	// RangeMisses is the number of range gets not cached, they are passed to the backend without filling the cache
+	RangeMisses int64
+	Evictions   int64
+	// Bypasses is the number of gets not cached because the backend did not provide ETag
+	Bypasses int64
+}
+
+// CachedStorage is Storage which caches objects of the backend in a local directory with size-bounded LRU.
+// Cached files are validated by ETag of the backend, and written atomically so that
+// multiple processes can share the same directory.
+type CachedStorage struct {
+	backend  Storage
+	dir      string
+	maxBytes int64
+	stats    CacheStats
+}
+
+// NewCachedStorage creates new CachedStorage in front of the backend.
+// maxBytes is the maximum total size of cached files in the directory.
+func NewCachedStorage(backend Storage, dir string, maxBytes int64) (*CachedStorage, error) {
+	serr := os.MkdirAll(dir, 0755)
+	if serr != nil {
+		return nil, fmt.Errorf("NewCachedStorage: %v", serr)
+	}
+	serr = sweepCacheTemp(dir, time.Now())
+	if serr != nil {
+		return nil, fmt.Errorf("NewCachedStorage: %v", serr)
+	}
+	s := new(CachedStorage)
+	s.backend = backend
+	s.dir = dir
+	s.maxBytes = maxBytes
+	return s, nil
+}
+
+// sweepCacheTemp removes temporary files older than cacheTempMaxAge in the directory.
+func sweepCacheTemp(dir string, now time.Time) error {
+	infos, serr := ioutil.ReadDir(dir)
+	if serr != nil {
+		return serr
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), cacheTempPrefix) || now.Sub(info.ModTime()) < cacheTempMaxAge {
+			continue
+		}
+		serr := os.Remove(filepath.Join(dir, info.Name()))
+		if serr != nil && !os.IsNotExist(serr) {
+			return serr
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics since the storage is created.
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		Hits:        atomic.LoadInt64(&s.stats.Hits),
+		Misses:      atomic.LoadInt64(&s.stats.Misses),
+		RangeMisses: atomic.LoadInt64(&s.stats.RangeMisses),
+		Evictions:   atomic.LoadInt64(&s.stats.Evictions),
+		Bypasses:    atomic.LoadInt64(&s.stats.Bypasses),
+	}
+}
+
+// DefaultCacheStats returns the statistics of the cache of the default storage,
+// ok is false if the cache is not enabled or the default storage could not be created.
+func DefaultCacheStats() (stats CacheStats, ok bool) {
+	s, serr := DefaultStorage()
+	if serr != nil {
+		return CacheStats{}, false
+	}
+	cs, ok := s.(*CachedStorage)
+	if !ok {
+		return CacheStats{}, false
+	}
+	return cs.Stats(), true
+}
+
+// path returns the path of the cached file for the version of the object.
+func (s *CachedStorage) path(key string, etag string) string {
+	h := sha256.Sum256([]byte(key + "\n" + etag))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:]))
+}
+
+// cacheError wraps the error with the name of the function unless it is *GetError of the backend,
+// which is returned as it is so that callers can retry.
+func cacheError(name string, err error) error {
+	var gerr *GetError
+	if errors.As(err, &gerr) {
+		return err
+	}
+	return fmt.Errorf("%s: %v", name, err)
+}
+
+// lookup returns the information of the object from the backend and opens the cached file of the version.
+// info is nil if the object does not exist, f is nil if it is not cached or the backend does not provide ETag.
+func (s *CachedStorage) lookup(ctx context.Context, key string) (info *ObjectInfo, f *os.File, err error) {
+	info, serr := s.backend.Stat(ctx, key)
+	if serr != nil {
+		return nil, nil, serr
+	}
+	if info == nil {
+		return nil, nil, nil
+	}
+	if info.ETag == "" {
+		atomic.AddInt64(&s.stats.Bypasses, 1)
+		return info, nil, nil
+	}
+	p := s.path(key, info.ETag)
+	f, serr = os.Open(p)
+	if serr != nil {
+		if os.IsNotExist(serr) {
+			// Misses are counted by callers
+			return info, nil, nil
+		}
+		return nil, nil, serr
+	}
+	atomic.AddInt64(&s.stats.Hits, 1)
+	// Mark as recently used
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return info, f, nil
+}
+
+// download downloads the object into the cache as the version of etag and opens it.
+// The object could be overwritten between Stat and Get, so the downloaded file is cached only if
+// ETag is still the same after the download, otherwise it is returned without being cached.
+// Returns nil file if the object does not exist.
+func (s *CachedStorage) download(ctx context.Context, key string, etag string) (f *os.File, err error) {
+	body, serr := s.backend.Get(ctx, key)
+	if serr != nil {
+		return nil, serr
+	}
+	if body == nil {
+		return nil, nil
+	}
+	defer body.Close()
+	tmp, serr := ioutil.TempFile(s.dir, cacheTempPrefix)
+	if serr != nil {
+		return nil, serr
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	_, serr = io.Copy(tmp, body)
+	if serr != nil {
+		tmp.Close()
+		return nil, serr
+	}
+	serr = tmp.Close()
+	if serr != nil {
+		return nil, serr
+	}
+	info, serr := s.backend.Stat(ctx, key)
+	if serr != nil {
+		return nil, serr
+	}
+	if info == nil || info.ETag != etag {
+		f, serr = os.Open(tmp.Name())
+		if serr != nil {
+			return nil, serr
+		}
+		// Opened file is still readable after removed
+		os.Remove(tmp.Name())
+		return f, nil
+	}
+	p := s.path(key, etag)
+	serr = os.Rename(tmp.Name(), p)
+	if serr != nil {
+		return nil, serr
+	}
+	// Open before evicting so that the file is readable even if it is evicted
+	f, serr = os.Open(p)
+	if serr != nil {
+		return nil, serr
+	}
+	serr = s.evict()
+	if serr != nil {
+		f.Close()
+		return nil, serr
+	}
+	return f, nil
+}
+
+// evict removes least recently used files until the total size fits in maxBytes.
+func (s *CachedStorage) evict() error {
+	infos, serr := ioutil.ReadDir(s.dir)
+	if serr != nil {
+		return serr
+	}
+	files := make([]os.FileInfo, 0, len(infos))
+	var total int64
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), cacheTempPrefix) {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if total <= s.maxBytes {
+			break
+		}
+		serr := os.Remove(filepath.Join(s.dir, info.Name()))
+		if serr != nil && !os.IsNotExist(serr) {
+			return serr
+		}
+		total -= info.Size()
+		atomic.AddInt64(&s.stats.Evictions, 1)
+	}
+	return nil
+}
+
+// Get returns the cached file of the object, downloading it from the backend if not cached.
+// *GetError of the backend is returned as it is.
+func (s *CachedStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	info, f, serr := s.lookup(ctx, key)
+	if serr != nil {
+		return nil, cacheError("Get", serr)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	if f != nil {
+		return f, nil
+	}
+	if info.ETag == "" {
+		return s.backend.Get(ctx, key)
+	}
+	atomic.AddInt64(&s.stats.Misses, 1)
+	f, serr = s.download(ctx, key, info.ETag)
+	if serr != nil {
+		return nil, cacheError("Get", serr)
+	}
+	if f == nil {
+		// Object was deleted after stat
+		return nil, nil
+	}
+	return f, nil
+}
+
+// GetRange reads the range from the cached file, or gets the range from the backend if not cached.
+// Only Get fills the cache, so that reading a small range of a large object does not download the whole.
+// *GetError of the backend is returned as it is.
+func (s *CachedStorage) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("GetRange: negative offset")
+	}
+	info, f, serr := s.lookup(ctx, key)
+	if serr != nil {
+		return nil, cacheError("GetRange", serr)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	if f == nil {
+		if info.ETag != "" {
+			atomic.AddInt64(&s.stats.RangeMisses, 1)
+		}
+		return s.backend.GetRange(ctx, key, offset, length)
+	}
+	_, serr = f.Seek(offset, io.SeekStart)
+	if serr != nil {
+		f.Close()
+		return nil, fmt.Errorf("GetRange: %v", serr)
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return readCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// Stat returns the information of the object from the backend.
+func (s *CachedStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return s.backend.Stat(ctx, key)
+}
+
+// Put puts the object to the backend, cached files of old versions will be evicted eventually.
+func (s *CachedStorage) Put(ctx context.Context, key string, body io.Reader, opts *PutOptions) error {
+	return s.backend.Put(ctx, key, body, opts)
+}
+
+// Tags returns the tags of the object from the backend.
+func (s *CachedStorage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	return s.backend.Tags(ctx, key)
+}
+
+// List lists objects of the backend.
+func (s *CachedStorage) List(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error) {
+	return s.backend.List(ctx, prefix, opts)
+}
@@ -0,0 +1,390 @@
+package streamcommons
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localStorageTempPrefix is the prefix of temporary files written by Put.
+const localStorageTempPrefix = ".tmp-"
+
+// localStorageMetaPrefix is the prefix of files storing metadata of the file with the rest of the name.
+const localStorageMetaPrefix = ".meta-"
+
+// LocalStorage is Storage on a local directory, keys are paths relative to the directory.
+type LocalStorage struct {
+	dir string
+}
+
+// localETag makes ETag from the size and the modification time of the file.
+func localETag(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano())
+}
+
+// NewLocalStorage creates new LocalStorage on the directory.
+func NewLocalStorage(dir string) *LocalStorage {
+	s := new(LocalStorage)
+	s.dir = filepath.Clean(dir)
+	return s
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("key '%s' is out of the directory", key)
+	}
+	return p, nil
+}
+
+// localMeta is the content of the metadata file.
+type localMeta struct {
+	// ETag is of the file the metadata belongs to
+	ETag     string            `json:"etag"`
+	Metadata map[string]string `json:"metadata"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// metaPath returns the path of the metadata file of the file.
+func metaPath(p string) string {
+	return filepath.Join(filepath.Dir(p), localStorageMetaPrefix+filepath.Base(p))
+}
+
+// readMeta reads the metadata file of the file whose ETag is etag, maps are empty if there is no metadata file.
+// Metadata of other content is ignored, it is left while Put is replacing the file and its metadata file
+// or if Put failed in the middle, metadata files without ETag are written by older versions and always used.
+func readMeta(p string, etag string) (*localMeta, error) {
+	empty := &localMeta{
+		Metadata: make(map[string]string),
+		Tags:     make(map[string]string),
+	}
+	marshaled, serr := ioutil.ReadFile(metaPath(p))
+	if serr != nil {
+		if os.IsNotExist(serr) {
+			return empty, nil
+		}
+		return nil, serr
+	}
+	meta := &localMeta{}
+	serr = json.Unmarshal(marshaled, meta)
+	if serr != nil {
+		return nil, serr
+	}
+	if meta.ETag != "" && meta.ETag != etag {
+		return empty, nil
+	}
+	if meta.Metadata == nil {
+		meta.Metadata = empty.Metadata
+	}
+	if meta.Tags == nil {
+		meta.Tags = empty.Tags
+	}
+	return meta, nil
+}
+
+// writeTemp writes the content of r to a new temporary file in the directory and returns its path.
+// The file is made readable by others as ioutil.TempFile creates it only for the owner.
+func writeTemp(dir string, r io.Reader) (name string, err error) {
+	tmp, serr := ioutil.TempFile(dir, localStorageTempPrefix)
+	if serr != nil {
+		return "", serr
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	_, serr = io.Copy(tmp, r)
+	if serr == nil {
+		serr = tmp.Chmod(0644)
+	}
+	if serr != nil {
+		tmp.Close()
+		return "", serr
+	}
+	serr = tmp.Close()
+	if serr != nil {
+		return "", serr
+	}
+	return tmp.Name(), nil
+}
+
+// Get opens the file of the key, returns nil if the file does not exist.
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, serr := s.path(key)
+	if serr != nil {
+		return nil, &GetError{Key: key, Err: serr}
+	}
+	f, serr := os.Open(p)
+	if serr != nil {
+		if os.IsNotExist(serr) {
+			return nil, nil
+		}
+		return nil, &GetError{Key: key, Err: serr}
+	}
+	return f, nil
+}
+
+// GetRange opens the file of the key and seeks to offset, returns nil if the file does not exist.
+func (s *LocalStorage) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("GetRange: negative offset")
+	}
+	body, serr := s.Get(ctx, key)
+	if serr != nil {
+		return nil, serr
+	}
+	if body == nil {
+		return nil, nil
+	}
+	f := body.(*os.File)
+	_, serr = f.Seek(offset, io.SeekStart)
+	if serr != nil {
+		f.Close()
+		return nil, &GetError{Key: key, Err: serr}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return readCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// Stat returns the information of the file, returns nil if the file does not exist.
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, serr := s.path(key)
+	if serr != nil {
+		return nil, fmt.Errorf("Stat: %v", serr)
+	}
+	info, serr := os.Stat(p)
+	if serr != nil {
+		if os.IsNotExist(serr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Stat: %v", serr)
+	}
+	oi, serr := objectInfo(key, p, info)
+	if serr != nil {
+		return nil, fmt.Errorf("Stat: %v", serr)
+	}
+	return oi, nil
+}
+
+// objectInfo makes the information of the file with its metadata.
+func objectInfo(key string, p string, info os.FileInfo) (*ObjectInfo, error) {
+	etag := localETag(info)
+	meta, serr := readMeta(p, etag)
+	if serr != nil {
+		return nil, fmt.Errorf("metadata: %v", serr)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ETag:         etag,
+		Metadata:     meta.Metadata,
+	}, nil
+}
+
+// getWithInfo opens the file of the key and returns the information of the opened file,
+// so that the metadata belongs to the content read even if Put replaces the file meanwhile.
+func (s *LocalStorage) getWithInfo(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	body, serr := s.Get(ctx, key)
+	if serr != nil {
+		return nil, nil, fmt.Errorf("getWithInfo: %v", serr)
+	}
+	if body == nil {
+		return nil, nil, nil
+	}
+	f := body.(*os.File)
+	info, serr := f.Stat()
+	if serr != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("getWithInfo: %v", serr)
+	}
+	oi, serr := objectInfo(key, f.Name(), info)
+	if serr != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("getWithInfo: %v", serr)
+	}
+	return f, oi, nil
+}
+
+// Put writes the body to the file of the key, creating parent directories.
+// The body and the metadata are written to temporary files first so that readers never see a partial file,
+// then the file is renamed into place before the metadata file, which has the ETag of the file
+// so that readers ignore metadata of the content being replaced.
+// Metadata and tags are stored in a hidden file next to it.
+func (s *LocalStorage) Put(ctx context.Context, key string, body io.Reader, opts *PutOptions) (err error) {
+	p, serr := s.path(key)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	dir := filepath.Dir(p)
+	serr = os.MkdirAll(dir, 0755)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	var cr *checksumReader
+	if opts != nil && opts.Checksum {
+		cr = newChecksumReader(body)
+		body = cr
+	}
+	tmp, serr := writeTemp(dir, body)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	// Renaming keeps the size and the modification time, so is the ETag
+	info, serr := os.Stat(tmp)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	meta := &localMeta{
+		ETag:     localETag(info),
+		Metadata: putMetadata(opts, cr),
+		Tags:     putTags(opts),
+	}
+	if len(meta.Metadata) == 0 && len(meta.Tags) == 0 {
+		serr = os.Rename(tmp, p)
+		if serr != nil {
+			return fmt.Errorf("Put: %v", serr)
+		}
+		serr = os.Remove(metaPath(p))
+		if serr != nil && !os.IsNotExist(serr) {
+			return fmt.Errorf("Put: metadata: %v", serr)
+		}
+		return nil
+	}
+	marshaled, serr := json.Marshal(meta)
+	if serr != nil {
+		return fmt.Errorf("Put: metadata: %v", serr)
+	}
+	metaTmp, serr := writeTemp(dir, bytes.NewReader(marshaled))
+	if serr != nil {
+		return fmt.Errorf("Put: metadata: %v", serr)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(metaTmp)
+		}
+	}()
+	serr = os.Rename(tmp, p)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	serr = os.Rename(metaTmp, metaPath(p))
+	if serr != nil {
+		return fmt.Errorf("Put: metadata: %v", serr)
+	}
+	return nil
+}
+
+// Tags returns the tags of the file, returns *GetError with ErrObjectNotFound if the file does not exist.
+func (s *LocalStorage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	p, serr := s.path(key)
+	if serr != nil {
+		return nil, fmt.Errorf("Tags: %v", serr)
+	}
+	info, serr := os.Stat(p)
+	if serr != nil {
+		if os.IsNotExist(serr) {
+			return nil, &GetError{Key: key, Err: ErrObjectNotFound}
+		}
+		return nil, fmt.Errorf("Tags: %v", serr)
+	}
+	meta, serr := readMeta(p, localETag(info))
+	if serr != nil {
+		return nil, fmt.Errorf("Tags: %v", serr)
+	}
+	return meta.Tags, nil
+}
+
+// errListDone stops walking directories when List has listed enough objects.
+var errListDone = errors.New("listed enough objects")
+
+// List lists files whose key starts with the prefix, temporary files being written are not listed.
+// It walks directories in lexicographical order of keys, skipping directories which can not have
+// keys in the range and stopping as soon as MaxKeys objects are listed.
+func (s *LocalStorage) List(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	var walk func(dir string, dirKey string) error
+	walk = func(dir string, dirKey string) error {
+		if serr := ctx.Err(); serr != nil {
+			return serr
+		}
+		infos, serr := ioutil.ReadDir(dir)
+		if serr != nil {
+			if os.IsNotExist(serr) && dirKey == "" {
+				// Nothing is stored yet
+				return nil
+			}
+			return serr
+		}
+		// Directory "a" comes after file "a-b" in keys because its keys start with "a/"
+		sortKey := func(info os.FileInfo) string {
+			if info.IsDir() {
+				return info.Name() + "/"
+			}
+			return info.Name()
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return sortKey(infos[i]) < sortKey(infos[j])
+		})
+		for _, info := range infos {
+			key := dirKey + sortKey(info)
+			if info.IsDir() {
+				inPrefix := strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key)
+				// All keys in the directory are before StartAfter
+				beforeStart := key < opts.StartAfter && !strings.HasPrefix(opts.StartAfter, key)
+				if !inPrefix || beforeStart {
+					continue
+				}
+				serr = walk(filepath.Join(dir, info.Name()), key)
+				if serr != nil {
+					return serr
+				}
+				continue
+			}
+			if strings.HasPrefix(info.Name(), localStorageTempPrefix) || strings.HasPrefix(info.Name(), localStorageMetaPrefix) {
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) {
+				if key > prefix {
+					// Keys after this are all out of the prefix
+					return errListDone
+				}
+				continue
+			}
+			if key <= opts.StartAfter {
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Key:          key,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+				ETag:         localETag(info),
+			})
+			if opts.MaxKeys > 0 && len(objects) >= opts.MaxKeys {
+				return errListDone
+			}
+		}
+		return nil
+	}
+	serr := walk(s.dir, "")
+	if serr != nil && serr != errListDone {
+		return nil, fmt.Errorf("List: %v", serr)
+	}
+	return objects, nil
+}
@@ -0,0 +1,142 @@
+package streamcommons
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is Storage on memory, useful for testing.
+type MemoryStorage struct {
+	objects map[string]*memoryObject
+	lock    sync.RWMutex
+}
+
+type memoryObject struct {
+	body         []byte
+	lastModified time.Time
+	etag         string
+	metadata     map[string]string
+	tags         map[string]string
+}
+
+// copyStringMap returns a copy of the map so that callers can not modify stored objects.
+func copyStringMap(m map[string]string) map[string]string {
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret
+}
+
+// NewMemoryStorage creates new empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	s := new(MemoryStorage)
+	s.objects = make(map[string]*memoryObject)
+	return s
+}
+
+// Get returns the reader for the object, returns nil if the key does not exist.
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.body)), nil
+}
+
+// GetRange returns the reader for the range of the object, returns nil if the key does not exist.
+func (s *MemoryStorage) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("GetRange: negative offset")
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil
+	}
+	body := obj.body
+	if offset >= int64(len(body)) {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	body = body[offset:]
+	if length >= 0 && length < int64(len(body)) {
+		body = body[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+// Stat returns the information of the object, returns nil if the key does not exist.
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(obj.body)),
+		LastModified: obj.lastModified,
+		ETag:         obj.etag,
+		Metadata:     copyStringMap(obj.metadata),
+	}, nil
+}
+
+// Put reads the whole body and stores it with metadata.
+func (s *MemoryStorage) Put(ctx context.Context, key string, body io.Reader, opts *PutOptions) error {
+	var cr *checksumReader
+	if opts != nil && opts.Checksum {
+		cr = newChecksumReader(body)
+		body = cr
+	}
+	obj, serr := ioutil.ReadAll(body)
+	if serr != nil {
+		return fmt.Errorf("Put: %v", serr)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[key] = &memoryObject{
+		body:         obj,
+		lastModified: time.Now(),
+		etag:         fmt.Sprintf("%x", md5.Sum(obj)),
+		metadata:     putMetadata(opts, cr),
+		tags:         putTags(opts),
+	}
+	return nil
+}
+
+// Tags returns the tags of the object, returns *GetError with ErrObjectNotFound if the key does not exist.
+func (s *MemoryStorage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, &GetError{Key: key, Err: ErrObjectNotFound}
+	}
+	return copyStringMap(obj.tags), nil
+}
+
+// List lists objects whose key starts with the prefix.
+func (s *MemoryStorage) List(ctx context.Context, prefix string, opts ListOptions) ([]ObjectInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	objects := make([]ObjectInfo, 0, len(s.objects))
+	for key, obj := range s.objects {
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(obj.body)),
+			LastModified: obj.lastModified,
+			ETag:         obj.etag,
+		})
+	}
+	return filterObjects(objects, prefix, opts), nil
+}